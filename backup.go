@@ -1,12 +1,21 @@
 package pogreb
 
 import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/akrylysov/pogreb/fs"
 )
 
+const (
+	backupManifestName = "backup" + metaExt
+)
+
 func touchFile(fsys fs.FileSystem, path string) error {
 	f, err := fsys.OpenFile(path, os.O_CREATE|os.O_TRUNC, os.FileMode(0640))
 	if err != nil {
@@ -29,6 +38,10 @@ func (db *DB) Backup(path string) error {
 	var segments []*segment
 	activeSegmentSizes := make(map[uint16]int64)
 	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if seg.compacted {
+			// All live records were moved to other segments.
+			continue
+		}
 		segments = append(segments, seg)
 		if !seg.meta.Full {
 			// Save the size of the active segments to copy only the data persisted up to the point
@@ -78,3 +91,427 @@ func (db *DB) Backup(path string) error {
 
 	return nil
 }
+
+// BackupSegment describes a segment stored in a backup.
+type BackupSegment struct {
+	ID         uint16 // Physical segment identifier.
+	SequenceID uint64 // Logical segment identifier.
+	Offset     int64  // Offset of the segment data stored in the backup, equal to Size if the backup holds no data.
+	Size       int64  // Size of the segment at the time of the backup.
+}
+
+func (bs BackupSegment) name() string {
+	return segmentName(bs.ID, bs.SequenceID)
+}
+
+// BackupManifest describes the segments of the DB at the time of the backup.
+type BackupManifest struct {
+	Segments []BackupSegment
+}
+
+// backupSegments returns the segments holding live records ordered from oldest to newest.
+// It's called with the DB read lock held.
+func (db *DB) backupSegments() []BackupSegment {
+	var segments []BackupSegment
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if seg.compacted {
+			// All live records were moved to other segments.
+			continue
+		}
+		// Save the size of the segments to copy only the data persisted up to the point of when the backup started.
+		segments = append(segments, BackupSegment{
+			ID:         seg.id,
+			SequenceID: seg.sequenceID,
+			Size:       seg.size,
+		})
+	}
+	return segments
+}
+
+// BackupIncremental creates a database backup at the specified path holding only the data written since the backup
+// described by the since manifest: segments created after the previous backup are copied in full and segments grown
+// since the previous backup are copied starting from their previous size.
+// The zero since manifest creates a full backup.
+// The returned manifest describes the new backup, it's also stored in the backup directory and can be read with
+// ReadBackupManifest.
+// Backups created by BackupIncremental can't be opened directly, use RestoreIncremental to assemble a chain of
+// backups into a DB.
+func (db *DB) BackupIncremental(path string, since BackupManifest) (BackupManifest, error) {
+	// Make sure the compaction is not running during backup.
+	db.maintenanceMu.Lock()
+	defer db.maintenanceMu.Unlock()
+
+	if err := db.opts.rootFS.MkdirAll(path, 0755); err != nil {
+		return BackupManifest{}, err
+	}
+
+	prev := make(map[uint64]BackupSegment, len(since.Segments))
+	for _, bs := range since.Segments {
+		prev[bs.SequenceID] = bs
+	}
+
+	db.mu.RLock()
+	manifest := BackupManifest{Segments: db.backupSegments()}
+	db.mu.RUnlock()
+
+	srcFS := db.opts.FileSystem
+	dstFS := fs.Sub(db.opts.rootFS, path)
+
+	for i := range manifest.Segments {
+		bs := &manifest.Segments[i]
+		if p, ok := prev[bs.SequenceID]; ok && p.ID == bs.ID {
+			if p.Size > bs.Size {
+				return BackupManifest{}, fmt.Errorf("segment %s is smaller than in the previous backup", bs.name())
+			}
+			bs.Offset = p.Size
+		}
+		if bs.Offset == bs.Size {
+			continue
+		}
+		if err := copyFileRange(srcFS, dstFS, bs.name(), bs.Offset, bs.Size-bs.Offset, false); err != nil {
+			return BackupManifest{}, err
+		}
+	}
+
+	if err := writeGobFile(dstFS, backupManifestName, &manifest); err != nil {
+		return BackupManifest{}, err
+	}
+
+	return manifest, nil
+}
+
+// copyFileRange copies size bytes starting at offset off of the source file to the destination file.
+// The data is appended to the destination file if appendData is true, otherwise the destination file is truncated.
+func copyFileRange(srcFS fs.FileSystem, dstFS fs.FileSystem, name string, off int64, size int64, appendData bool) error {
+	mode := os.FileMode(0640)
+	srcFile, err := srcFS.OpenFile(name, os.O_RDONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	flag := os.O_CREATE | os.O_RDWR
+	if !appendData {
+		flag |= os.O_TRUNC
+	}
+	dstFile, err := dstFS.OpenFile(name, flag, mode)
+	if err != nil {
+		_ = srcFile.Close()
+		return err
+	}
+
+	err = copyRange(srcFile, dstFile, off, size)
+	if closeErr := srcFile.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func copyRange(src fs.File, dst fs.File, off int64, size int64) error {
+	if _, err := src.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := dst.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	_, err := io.CopyN(dst, src, size)
+	return err
+}
+
+// ReadBackupManifest reads the manifest of the backup created by BackupIncremental at the specified path.
+func ReadBackupManifest(path string, opts *Options) (BackupManifest, error) {
+	opts, err := opts.copyWithDefaults(path)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	var manifest BackupManifest
+	if err := readGobFile(opts.FileSystem, backupManifestName, &manifest); err != nil {
+		return BackupManifest{}, err
+	}
+	return manifest, nil
+}
+
+// RestoreIncremental assembles a chain of backups created by BackupIncremental into a database at the specified path.
+// The backups must be ordered from the oldest to the newest, the oldest backup must be a full backup and each
+// following backup must be created with the manifest of the previous backup.
+// The restored database contains the data of the newest backup.
+func RestoreIncremental(path string, backups []string, opts *Options) error {
+	if len(backups) == 0 {
+		return fmt.Errorf("no backups to restore")
+	}
+	opts, err := opts.copyWithDefaults(path)
+	if err != nil {
+		return err
+	}
+
+	manifests := make([]BackupManifest, len(backups))
+	for i, backup := range backups {
+		var err error
+		if manifests[i], err = ReadBackupManifest(backup, &Options{FileSystem: opts.rootFS}); err != nil {
+			return fmt.Errorf("reading manifest of backup %s: %v", backup, err)
+		}
+	}
+
+	if err := opts.rootFS.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+
+	last := manifests[len(manifests)-1]
+	for _, bs := range last.Segments {
+		// Assemble the segment from the pieces stored in the backups.
+		var size int64
+		for i, m := range manifests {
+			for _, piece := range m.Segments {
+				if piece.SequenceID != bs.SequenceID || piece.ID != bs.ID || piece.Offset == piece.Size {
+					continue
+				}
+				if piece.Offset != size {
+					return fmt.Errorf("backup %s doesn't continue segment %s at offset %d", backups[i], bs.name(), size)
+				}
+				srcFS := fs.Sub(opts.rootFS, backups[i])
+				if err := copyFileRange(srcFS, opts.FileSystem, bs.name(), 0, piece.Size-piece.Offset, size > 0); err != nil {
+					return err
+				}
+				size = piece.Size
+			}
+		}
+		if size != bs.Size {
+			return fmt.Errorf("backup chain is missing data of segment %s", bs.name())
+		}
+	}
+
+	// Opening the restored database rebuilds the index.
+	return touchFile(opts.FileSystem, lockName)
+}
+
+// BackupTo writes a tar archive of the database segments to w.
+// Wrap w with gzip.Writer to create a compressed archive, Restore detects compressed archives automatically.
+func (db *DB) BackupTo(w io.Writer) error {
+	// Make sure the compaction is not running during backup.
+	db.maintenanceMu.Lock()
+	defer db.maintenanceMu.Unlock()
+
+	db.mu.RLock()
+	segments := db.backupSegments()
+	db.mu.RUnlock()
+
+	tw := tar.NewWriter(w)
+	for _, bs := range segments {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     bs.name(),
+			Mode:     0640,
+			Size:     bs.Size,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := db.opts.FileSystem.OpenFile(hdr.Name, os.O_RDONLY, os.FileMode(0640))
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, f, bs.Size)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Restore unpacks a database archive created by BackupTo into an empty directory at the specified path.
+// All segment records are verified before the index is rebuilt, opening the restored database doesn't require
+// recovery.
+func Restore(r io.Reader, path string, opts *Options) error {
+	// Detect gzip-compressed archives.
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	dbOpts, err := opts.copyWithDefaults(path)
+	if err != nil {
+		return err
+	}
+	if err := dbOpts.rootFS.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	fsys := dbOpts.FileSystem
+	files, err := fsys.ReadDir(".")
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("restore directory %s is not empty", path)
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		id, seqID, err := parseSegmentName(hdr.Name)
+		if err != nil || hdr.Typeflag != tar.TypeReg || segmentName(id, seqID) != hdr.Name {
+			return fmt.Errorf("unexpected file %s in backup archive", hdr.Name)
+		}
+		if err := restoreSegment(fsys, tr, id, hdr.Name); err != nil {
+			return err
+		}
+	}
+
+	// Rebuild the index by recovering the database.
+	if err := touchFile(fsys, lockName); err != nil {
+		return err
+	}
+	restoreOpts := Options{}
+	if opts != nil {
+		restoreOpts = *opts
+	}
+	restoreOpts.ReadOnly = false
+	db, err := Open(path, &restoreOpts)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// restoreSegment writes the segment data read from r and verifies the segment header and records.
+func restoreSegment(fsys fs.FileSystem, r io.Reader, id uint16, name string) error {
+	dst, err := fsys.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(0640))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := openFile(fsys, name, openFileFlags{readOnly: true})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	it, err := newSegmentIterator(&segment{file: f, id: id, name: name})
+	if err != nil {
+		return err
+	}
+	for {
+		_, err := it.next()
+		if err == ErrIterationDone {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return it.corrupted("truncated record")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Checkpoint creates a database copy at the specified path.
+// Full segments are never modified, they are hard-linked to the checkpoint when the file system implements fs.Linker,
+// making the checkpoint nearly instant. Only the data of the active segment is copied.
+// The newest segment is always copied, it becomes the segment written to by the checkpoint.
+// Checkpoint falls back to copying full segments when hard links are not supported.
+// Hard-linked segments are shared by the DB and the checkpoint and must be treated as immutable: neither of them
+// writes to full segments, but recovering either of them after a crash may truncate a corrupted shared segment.
+// The index is copied while the DB read lock is held, the checkpoint opens without recovery.
+func (db *DB) Checkpoint(path string) error {
+	// Make sure the compaction is not running during checkpoint.
+	db.maintenanceMu.Lock()
+	defer db.maintenanceMu.Unlock()
+
+	if err := db.opts.rootFS.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+
+	srcFS := db.opts.FileSystem
+	dstFS := fs.Sub(db.opts.rootFS, path)
+	linker, canLink := db.opts.rootFS.(fs.Linker)
+
+	// The lock file makes the incomplete checkpoint recover when opened, it's removed once all files are written.
+	if err := touchFile(dstFS, lockName); err != nil {
+		return err
+	}
+
+	db.mu.RLock()
+	segments := db.backupSegments()
+	fullSegments := make(map[uint16]bool)
+	for _, seg := range db.datalog.segments {
+		if seg != nil && seg.meta.Full {
+			fullSegments[seg.id] = true
+		}
+	}
+	err := db.checkpointIndex(dstFS, segments)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for i, bs := range segments {
+		name := bs.name()
+		// Never write into a file left by a previous checkpoint, it may be a hard link to the DB segment.
+		if err := dstFS.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// The newest segment becomes the current segment of the checkpoint, it's always copied.
+		if canLink && fullSegments[bs.ID] && i < len(segments)-1 {
+			err := linker.Link(filepath.Join(db.opts.path, name), filepath.Join(path, name))
+			if err == nil {
+				continue
+			}
+			// Hard links are not supported by the underlying file system or can't cross devices, copy the segment.
+		}
+		if err := copyFileRange(srcFS, dstFS, name, 0, bs.Size, false); err != nil {
+			return err
+		}
+	}
+
+	return dstFS.Remove(lockName)
+}
+
+// checkpointIndex copies the index and writes the DB and segment metadata matching the checkpoint segments.
+// It's called with the DB read lock held.
+func (db *DB) checkpointIndex(dstFS fs.FileSystem, segments []BackupSegment) error {
+	srcFS := db.opts.FileSystem
+	idx := db.index
+	if err := copyFileRange(srcFS, dstFS, indexMainName, 0, idx.main.size, false); err != nil {
+		return err
+	}
+	if err := copyFileRange(srcFS, dstFS, indexOverflowName, 0, idx.overflow.size, false); err != nil {
+		return err
+	}
+	if err := writeGobFile(dstFS, indexMetaName, idx.meta()); err != nil {
+		return err
+	}
+	for i, bs := range segments {
+		meta := *db.datalog.segments[bs.ID].meta
+		if i == len(segments)-1 {
+			// The newest segment becomes the current segment of the checkpoint.
+			meta.Full = false
+		}
+		if err := writeGobFile(dstFS, bs.name()+metaExt, &meta); err != nil {
+			return err
+		}
+	}
+	return writeGobFile(dstFS, dbMetaName, dbMeta{HashSeed: db.hashSeed})
+}
@@ -1,8 +1,17 @@
 package pogreb
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/akrylysov/pogreb/fs"
 	"github.com/akrylysov/pogreb/internal/assert"
 )
 
@@ -10,9 +19,9 @@ const testDBBackupName = testDBName + ".backup"
 
 func TestBackup(t *testing.T) {
 	opts := &Options{
-		maxSegmentSize:             1024,
-		compactionMinSegmentSize:   520,
-		compactionMinFragmentation: 0.02,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
 	}
 
 	run := func(name string, f func(t *testing.T, db *DB)) bool {
@@ -61,3 +70,246 @@ func TestBackup(t *testing.T) {
 		assert.Nil(t, db2.Close())
 	})
 }
+
+func TestBackupIncremental(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	var backups []string
+	for i := 0; i < 4; i++ {
+		backups = append(backups, fmt.Sprintf("%s.%d", testDBBackupName, i))
+	}
+	for _, path := range append(backups, testDBBackupName) {
+		assert.Nil(t, cleanDir(path))
+	}
+
+	for i := byte(0); i < 50; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+	}
+	m0, err := db.BackupIncremental(backups[0], BackupManifest{})
+	assert.Nil(t, err)
+	for _, bs := range m0.Segments {
+		assert.Equal(t, int64(0), bs.Offset)
+	}
+
+	// Grow the active segment and create new segments.
+	for i := byte(50); i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+	}
+	m1, err := db.BackupIncremental(backups[1], m0)
+	assert.Nil(t, err)
+	assert.Equal(t, m0.Segments[1].Size, m1.Segments[1].Offset)
+	m, err := ReadBackupManifest(backups[1], &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, m1, m)
+
+	// Remove segments with compaction.
+	for i := byte(0); i < 25; i++ {
+		assert.Nil(t, db.Delete([]byte{i}))
+	}
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, true, cr.CompactedSegments > 0)
+	m2, err := db.BackupIncremental(backups[2], m1)
+	assert.Nil(t, err)
+
+	for i := byte(0); i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i, i}))
+	}
+	m3, err := db.BackupIncremental(backups[3], m2)
+	assert.Nil(t, err)
+	grown := 0
+	for _, bs := range m3.Segments {
+		if bs.Offset > 0 && bs.Offset < bs.Size {
+			grown++
+		}
+	}
+	assert.Equal(t, 1, grown)
+
+	assert.Nil(t, RestoreIncremental(testDBBackupName, backups, &Options{FileSystem: testFS}))
+	db2, err := Open(testDBBackupName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, dbItems(t, db), dbItems(t, db2))
+	assert.Nil(t, db2.Close())
+
+	// Restoring a chain with a missing backup fails.
+	assert.Nil(t, cleanDir(testDBBackupName))
+	err = RestoreIncremental(testDBBackupName, []string{backups[0], backups[1], backups[3]}, &Options{FileSystem: testFS})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, db.Close())
+	for _, path := range backups {
+		assert.Nil(t, cleanDir(path))
+	}
+}
+
+func TestBackupTo(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := byte(0); i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+	}
+	assert.Nil(t, db.Delete([]byte{0}))
+	assert.Equal(t, 3, countSegments(t, db))
+	items := dbItems(t, db)
+
+	var archive bytes.Buffer
+	assert.Nil(t, db.BackupTo(&archive))
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	assert.Nil(t, db.BackupTo(zw))
+	assert.Nil(t, zw.Close())
+	assert.Nil(t, db.Close())
+
+	restore := func(t *testing.T, data []byte) error {
+		assert.Nil(t, cleanDir(testDBBackupName))
+		return Restore(bytes.NewReader(data), testDBBackupName, &Options{FileSystem: testFS})
+	}
+
+	for name, data := range map[string][]byte{"tar": archive.Bytes(), "gzip": compressed.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			assert.Nil(t, restore(t, data))
+			// The restored DB doesn't need recovery.
+			db2, err := Open(testDBBackupName, &Options{FileSystem: testFS, ReadOnly: true})
+			assert.Nil(t, err)
+			assert.Equal(t, items, dbItems(t, db2))
+			assert.Nil(t, db2.Close())
+		})
+	}
+
+	t.Run("corrupted", func(t *testing.T) {
+		data := cloneBytes(archive.Bytes())
+		// Corrupt the value of the first record of the first segment.
+		data[512+headerSize+7] ^= 0xff
+		err := restore(t, data)
+		assert.Equal(t, true, errors.Is(err, ErrCorrupted))
+	})
+
+	t.Run("not empty", func(t *testing.T) {
+		assert.Nil(t, restore(t, archive.Bytes()))
+		err := Restore(bytes.NewReader(archive.Bytes()), testDBBackupName, &Options{FileSystem: testFS})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("unexpected file", func(t *testing.T) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../00000-1.psg", Mode: 0640}))
+		assert.Nil(t, tw.Close())
+		assert.NotNil(t, restore(t, buf.Bytes()))
+	})
+
+	assert.Nil(t, cleanDir(testDBBackupName))
+}
+
+func TestCheckpoint(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, cleanDir(testDBBackupName))
+	for i := byte(0); i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+	}
+	assert.Equal(t, 3, countSegments(t, db))
+	items := dbItems(t, db)
+
+	assert.Nil(t, db.Checkpoint(testDBBackupName))
+	// Checkpointing into the same directory replaces the previous checkpoint.
+	assert.Nil(t, db.Checkpoint(testDBBackupName))
+
+	// Full segments are hard-linked when the file system supports it.
+	if _, ok := testFS.(fs.Linker); ok {
+		for _, seg := range db.datalog.segmentsBySequenceID() {
+			fi, err := testFS.Stat(filepath.Join(testDBName, seg.name))
+			assert.Nil(t, err)
+			fi2, err := testFS.Stat(filepath.Join(testDBBackupName, seg.name))
+			assert.Nil(t, err)
+			assert.Equal(t, seg.meta.Full, os.SameFile(fi, fi2))
+		}
+	}
+
+	// Writes after the checkpoint don't modify the checkpoint.
+	assert.Nil(t, db.Put([]byte{0}, []byte{1}))
+	assert.Nil(t, db.Put([]byte{100}, []byte{100}))
+
+	// The checkpoint has a clean index and opens without recovery.
+	assert.Equal(t, false, fileExists(filepath.Join(testDBBackupName, lockName)))
+	db2, err := Open(testDBBackupName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db2.Metrics().Recoveries.Value())
+	assert.Equal(t, items, dbItems(t, db2))
+	report, err := db2.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.Problems))
+	assert.Nil(t, db2.Put([]byte{101}, []byte{101}))
+	assert.Nil(t, db2.Close())
+
+	assert.Nil(t, db.Close())
+	assert.Nil(t, cleanDir(testDBBackupName))
+}
+
+func TestCheckpointFullCurrentSegment(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, cleanDir(testDBBackupName))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{0}, []byte{byte(i)}))
+	}
+	// The canceled compaction marks the current segment as full without switching to a new segment.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = db.CompactContext(ctx, CompactOptions{})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, true, db.datalog.segments[0].meta.Full)
+	assert.Nil(t, db.Checkpoint(testDBBackupName))
+	assert.Nil(t, db.Close())
+
+	segmentSizes := func() map[string]int64 {
+		sizes := make(map[string]int64)
+		files, err := testFS.ReadDir(testDBName)
+		assert.Nil(t, err)
+		for _, file := range files {
+			if filepath.Ext(file.Name()) != segmentExt {
+				continue
+			}
+			fi, err := testFS.Stat(filepath.Join(testDBName, file.Name()))
+			assert.Nil(t, err)
+			sizes[file.Name()] = fi.Size()
+		}
+		return sizes
+	}
+	sizes := segmentSizes()
+
+	// Writes to the checkpoint don't modify the DB segments.
+	db2, err := Open(testDBBackupName, opts)
+	assert.Nil(t, err)
+	for i := byte(1); i < 10; i++ {
+		assert.Nil(t, db2.Put([]byte{i}, []byte{i}))
+	}
+	assert.Nil(t, db2.Close())
+	assert.Equal(t, sizes, segmentSizes())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), db.Count())
+	assert.Nil(t, db.Close())
+	assert.Nil(t, cleanDir(testDBBackupName))
+}
@@ -0,0 +1,124 @@
+package pogreb
+
+import (
+	"time"
+)
+
+// batchOp is a single WriteBatch operation.
+type batchOp struct {
+	rtype     recordType
+	offset    uint32 // Offset of the encoded record in the batch data.
+	keySize   uint16
+	valueSize uint32
+}
+
+// WriteBatch holds a sequence of Put and Delete operations applied to the DB atomically by the DB.Write method.
+// A WriteBatch is not safe for concurrent use by multiple goroutines.
+type WriteBatch struct {
+	data []byte // Encoded records.
+	ops  []batchOp
+}
+
+// NewWriteBatch returns a new empty WriteBatch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+func (b *WriteBatch) append(key []byte, value []byte, rt recordType) {
+	b.ops = append(b.ops, batchOp{
+		rtype:     rt,
+		offset:    uint32(len(b.data)),
+		keySize:   uint16(len(key)),
+		valueSize: uint32(len(value)),
+	})
+	b.data = append(b.data, encodeRecord(key, value, rt)...)
+}
+
+// Put adds setting the value for the given key to the batch.
+func (b *WriteBatch) Put(key []byte, value []byte) error {
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLarge
+	}
+	if len(value) > MaxValueLength {
+		return ErrValueTooLarge
+	}
+	b.append(key, value, recordTypePut)
+	return nil
+}
+
+// Delete adds deleting the given key to the batch.
+func (b *WriteBatch) Delete(key []byte) error {
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLarge
+	}
+	b.append(key, nil, recordTypeDelete)
+	return nil
+}
+
+// Len returns the number of operations in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset clears the batch, allowing it to be reused.
+func (b *WriteBatch) Reset() {
+	b.data = b.data[:0]
+	b.ops = b.ops[:0]
+}
+
+// key returns the key of the batch operation.
+func (b *WriteBatch) key(op batchOp) []byte {
+	off := op.offset + 6 // Skip key size and value size.
+	return b.data[off : off+uint32(op.keySize)]
+}
+
+// Write applies the batch operations to the DB in order.
+// The batch is atomic: in the event of a crash either all or none of the batch operations are recovered.
+func (db *DB) Write(b *WriteBatch) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	defer db.metrics.WriteLatency.observeSince(time.Now())
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.following {
+		return ErrReadOnly
+	}
+
+	segID, offset, err := db.datalog.writeBatch(b)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range b.ops {
+		key := b.key(op)
+		h := db.hash(key)
+		switch op.rtype {
+		case recordTypePut:
+			db.metrics.Puts.Add(1)
+			sl := slot{
+				hash:      h,
+				segmentID: segID,
+				keySize:   op.keySize,
+				valueSize: op.valueSize,
+				offset:    offset + op.offset,
+			}
+			if err := db.put(sl, key); err != nil {
+				return err
+			}
+		case recordTypeDelete:
+			db.metrics.Dels.Add(1)
+			if err := db.del(h, key, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	if db.syncWrites {
+		return db.sync()
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package pogreb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestWriteBatch(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+
+	b := NewWriteBatch()
+	assert.Nil(t, db.Write(b))
+	assert.Nil(t, b.Put([]byte{2}, []byte{2}))
+	assert.Nil(t, b.Put([]byte{3}, []byte{3}))
+	assert.Nil(t, b.Delete([]byte{1}))
+	assert.Nil(t, b.Put([]byte{2}, []byte{4}))
+	assert.Equal(t, 4, b.Len())
+	assert.Nil(t, db.Write(b))
+
+	assert.Equal(t, uint32(2), db.Count())
+	v, err := db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{4}, v)
+	v, err = db.Get([]byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, v)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+
+	// Batch record (15 bytes), delete record (11 bytes), two overwritten put records (12 bytes each).
+	assert.Equal(t, &segmentMeta{PutRecords: 4, DeleteRecords: 1, DeletedKeys: 2, DeletedBytes: 50}, db.datalog.segments[0].meta)
+	assert.Equal(t, int64(4), db.Metrics().Puts.Value())
+	assert.Equal(t, int64(1), db.Metrics().Dels.Value())
+
+	b.Reset()
+	assert.Equal(t, 0, b.Len())
+	assert.Nil(t, b.Delete([]byte{3}))
+	assert.Nil(t, db.Write(b))
+	assert.Equal(t, uint32(1), db.Count())
+
+	assert.Nil(t, db.Close())
+}
+
+func TestWriteBatchTooLarge(t *testing.T) {
+	b := NewWriteBatch()
+	assert.Equal(t, ErrKeyTooLarge, b.Put(make([]byte, MaxKeyLength+1), nil))
+	assert.Equal(t, ErrKeyTooLarge, b.Delete(make([]byte, MaxKeyLength+1)))
+	assert.Equal(t, 0, b.Len())
+}
+
+func TestWriteBatchRecovery(t *testing.T) {
+	segPath := filepath.Join(testDBName, segmentName(0, 1))
+	opts := &Options{FileSystem: testFS}
+
+	writeTestDB := func(t *testing.T) int64 {
+		db, err := createTestDB(opts)
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+		b := NewWriteBatch()
+		assert.Nil(t, b.Put([]byte{2}, []byte{2}))
+		assert.Nil(t, b.Delete([]byte{1}))
+		assert.Nil(t, b.Put([]byte{3}, []byte{3}))
+		assert.Nil(t, db.Write(b))
+		size := db.datalog.segments[0].size
+		assert.Nil(t, db.Close())
+		// Simulate crash.
+		assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+		return size
+	}
+
+	t.Run("complete batch", func(t *testing.T) {
+		writeTestDB(t)
+		db, err := Open(testDBName, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(2), db.Count())
+		has, err := db.Has([]byte{1})
+		assert.Nil(t, err)
+		assert.Equal(t, false, has)
+		v, err := db.Get([]byte{3})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{3}, v)
+		assert.Equal(t, &segmentMeta{PutRecords: 3, DeleteRecords: 1, DeletedKeys: 1, DeletedBytes: 38}, db.datalog.segments[0].meta)
+		assert.Nil(t, db.Close())
+	})
+
+	t.Run("partial batch", func(t *testing.T) {
+		size := writeTestDB(t)
+		f, err := testFS.OpenFile(segPath, os.O_RDWR, os.FileMode(0640))
+		assert.Nil(t, err)
+		assert.Nil(t, f.Truncate(size-1))
+		assert.Nil(t, f.Close())
+
+		db, err := Open(testDBName, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(1), db.Count())
+		v, err := db.Get([]byte{1})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{1}, v)
+		for _, k := range []byte{2, 3} {
+			has, err := db.Has([]byte{k})
+			assert.Nil(t, err)
+			assert.Equal(t, false, has)
+		}
+		// The segment is truncated to the beginning of the batch.
+		assert.Equal(t, int64(headerSize+12), db.datalog.segments[0].size)
+		assert.Nil(t, db.Close())
+	})
+}
+
+func TestWriteBatchCompaction(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	b := NewWriteBatch()
+	for i := byte(0); i < 10; i++ {
+		assert.Nil(t, b.Put([]byte{i}, []byte{i}))
+	}
+	assert.Nil(t, db.Write(b))
+	b.Reset()
+	for i := byte(0); i < 5; i++ {
+		assert.Nil(t, b.Delete([]byte{i}))
+	}
+	assert.Nil(t, db.Write(b))
+
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	// Two batch records, five delete records and five overwritten put records.
+	assert.Equal(t, CompactionResult{CompactedSegments: 1, ReclaimedRecords: 12, ReclaimedBytes: 145}, cr)
+	assert.Equal(t, uint32(5), db.Count())
+	for i := byte(5); i < 10; i++ {
+		v, err := db.Get([]byte{i})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{i}, v)
+	}
+
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,175 @@
+package pogreb
+
+import (
+	"bytes"
+	"time"
+)
+
+// keyLookup is the result of a single index walk for a key that is about to be modified.
+type keyLookup struct {
+	h     uint32
+	sw    *slotWriter // Points to the slot of the key or to the slot where a new key can be inserted.
+	found bool        // Reports whether the key exists in the index, including expired keys.
+	slot  slot        // Slot of the existing key.
+	rec   record      // Record of the existing key, without the value.
+	live  bool        // Reports whether the key exists and isn't expired.
+	value []byte      // Value of the live key, read only when requested.
+}
+
+// lookupKey finds the key in the index.
+// The value of the live key is read when withValue is true.
+// The returned lookup is valid only while the DB write lock is held.
+func (db *DB) lookupKey(key []byte, withValue bool) (*keyLookup, error) {
+	l := &keyLookup{h: db.hash(key)}
+	sw, found, err := db.index.findInsertionBucket(slot{hash: l.h}, func(cursl slot) (bool, error) {
+		if uint16(len(key)) != cursl.keySize {
+			return false, nil
+		}
+		rec, err := db.datalog.readRecord(cursl, false)
+		if err != nil {
+			return true, err
+		}
+		if bytes.Equal(key, rec.key) {
+			l.slot = cursl
+			l.rec = rec
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.sw = sw
+	l.found = found
+	l.live = found && !l.rec.expired(db.now())
+	if l.live && withValue {
+		rec, err := db.datalog.readRecord(l.slot, true)
+		if err != nil {
+			return nil, err
+		}
+		if l.value, err = db.recordValue(rec); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// insertAt stores the slot of the record written for the key at the position found by lookupKey.
+func (db *DB) insertAt(l *keyLookup, key []byte, segmentID uint16, offset uint32, valueSize uint32) error {
+	if !l.found && db.index.count() == MaxKeys {
+		return ErrFull
+	}
+	if l.found {
+		db.trackSnapshots(key, l.slot, true)
+		db.datalog.trackDel(l.slot, l.rec) // Overwriting existing key.
+	} else {
+		db.trackSnapshots(key, slot{hash: l.h}, false)
+	}
+	sl := slot{
+		hash:      l.h,
+		segmentID: segmentID,
+		keySize:   uint16(len(key)),
+		valueSize: valueSize,
+		offset:    offset,
+	}
+	return db.index.insert(l.sw, sl, l.found)
+}
+
+// putAt writes the put record with an optional expiration time for the key found by lookupKey.
+func (db *DB) putAt(l *keyLookup, key []byte, value []byte, expiresAt int64) error {
+	segID, offset, err := db.datalog.putWithTTL(key, value, expiresAt)
+	if err != nil {
+		return err
+	}
+	return db.insertAt(l, key, segID, offset, uint32(len(value)))
+}
+
+// deleteAt deletes the existing key found by lookupKey.
+// The delete record is written before the slot is removed, the index isn't modified if the write fails.
+func (db *DB) deleteAt(l *keyLookup, key []byte) error {
+	if err := db.datalog.del(key); err != nil {
+		return err
+	}
+	db.trackSnapshots(key, l.slot, true)
+	db.datalog.trackDel(l.slot, l.rec)
+	b := l.sw.bucket
+	b.del(l.sw.slotIdx)
+	if err := b.write(); err != nil {
+		return err
+	}
+	db.index.numKeys--
+	return nil
+}
+
+// conditionalWrite runs the write function under the DB write lock if the condition holds for the current state
+// of the key.
+func (db *DB) conditionalWrite(key []byte, cond func(l *keyLookup) bool, write func(l *keyLookup) error) (bool, error) {
+	if db.opts.ReadOnly {
+		return false, ErrReadOnly
+	}
+	if len(key) > MaxKeyLength {
+		return false, ErrKeyTooLarge
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.following {
+		return false, ErrReadOnly
+	}
+	l, err := db.lookupKey(key, true)
+	if err != nil {
+		return false, err
+	}
+	if !cond(l) {
+		return false, nil
+	}
+	if err := write(l); err != nil {
+		return false, err
+	}
+	if db.syncWrites {
+		return true, db.sync()
+	}
+	return true, nil
+}
+
+// CompareAndSwap sets the value for the given key to newValue only if the key exists and its current value equals
+// oldValue.
+// It returns true if the value was swapped.
+func (db *DB) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error) {
+	if len(newValue) > MaxValueLength {
+		return false, ErrValueTooLarge
+	}
+	defer db.metrics.PutLatency.observeSince(time.Now())
+	return db.conditionalWrite(key, func(l *keyLookup) bool {
+		return l.live && bytes.Equal(l.value, oldValue)
+	}, func(l *keyLookup) error {
+		db.metrics.Puts.Add(1)
+		return db.putAt(l, key, newValue, 0)
+	})
+}
+
+// PutIfAbsent sets the value for the given key only if the key doesn't exist.
+// It returns true if the value was set.
+func (db *DB) PutIfAbsent(key []byte, value []byte) (bool, error) {
+	if len(value) > MaxValueLength {
+		return false, ErrValueTooLarge
+	}
+	defer db.metrics.PutLatency.observeSince(time.Now())
+	return db.conditionalWrite(key, func(l *keyLookup) bool {
+		return !l.live
+	}, func(l *keyLookup) error {
+		db.metrics.Puts.Add(1)
+		return db.putAt(l, key, value, 0)
+	})
+}
+
+// DeleteIfEquals deletes the given key only if its current value equals value.
+// It returns true if the key was deleted.
+func (db *DB) DeleteIfEquals(key []byte, value []byte) (bool, error) {
+	defer db.metrics.DeleteLatency.observeSince(time.Now())
+	return db.conditionalWrite(key, func(l *keyLookup) bool {
+		return l.live && bytes.Equal(l.value, value)
+	}, func(l *keyLookup) error {
+		db.metrics.Dels.Add(1)
+		return db.deleteAt(l, key)
+	})
+}
@@ -0,0 +1,155 @@
+package pogreb
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	// Missing key.
+	ok, err := db.CompareAndSwap([]byte{1}, nil, []byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, uint32(0), db.Count())
+
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+
+	// Value mismatch.
+	ok, err = db.CompareAndSwap([]byte{1}, []byte{2}, []byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ok)
+
+	ok, err = db.CompareAndSwap([]byte{1}, []byte{1}, []byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ok)
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, v)
+	assert.Equal(t, uint32(1), db.Count())
+	assert.Equal(t, &segmentMeta{PutRecords: 2, DeletedKeys: 1, DeletedBytes: 12}, db.datalog.segments[0].meta)
+
+	assert.Nil(t, db.Close())
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	clock := newTestClock()
+	db, err := createTestDB(&Options{now: clock.now})
+	assert.Nil(t, err)
+
+	ok, err := db.PutIfAbsent([]byte{1}, []byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ok)
+	ok, err = db.PutIfAbsent([]byte{1}, []byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ok)
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+
+	// Expired keys are absent.
+	assert.Nil(t, db.PutWithTTL([]byte{2}, []byte{2}, time.Second))
+	ok, err = db.PutIfAbsent([]byte{2}, []byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ok)
+	clock.advance(time.Second)
+	ok, err = db.PutIfAbsent([]byte{2}, []byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ok)
+	v, err = db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, v)
+	assert.Equal(t, uint32(2), db.Count())
+
+	// Inserting enough keys to split the index.
+	for i := 0; i < 100; i++ {
+		ok, err = db.PutIfAbsent([]byte{byte(i), 0}, []byte{byte(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, true, ok)
+	}
+	assert.Equal(t, uint32(102), db.Count())
+	for i := 0; i < 100; i++ {
+		v, err = db.Get([]byte{byte(i), 0})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{byte(i)}, v)
+	}
+
+	assert.Nil(t, db.Close())
+}
+
+func TestDeleteIfEquals(t *testing.T) {
+	opts := &Options{FileSystem: testFS}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	ok, err := db.DeleteIfEquals([]byte{1}, []byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ok)
+
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+	ok, err = db.DeleteIfEquals([]byte{1}, []byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, false, ok)
+	ok, err = db.DeleteIfEquals([]byte{1}, []byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, true, ok)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	assert.Equal(t, uint32(0), db.Count())
+	assert.Equal(t, &segmentMeta{PutRecords: 1, DeleteRecords: 1, DeletedKeys: 1, DeletedBytes: 23}, db.datalog.segments[0].meta)
+
+	// The delete record is persisted.
+	assert.Nil(t, db.Close())
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), db.Count())
+	assert.Nil(t, db.Close())
+}
+
+// failOpenFS fails opening files when fail is set.
+type failOpenFS struct {
+	fs.FileSystem
+	fail bool
+}
+
+var errOpenFailed = errors.New("open failed")
+
+func (f *failOpenFS) OpenFile(name string, flag int, perm os.FileMode) (fs.File, error) {
+	if f.fail {
+		return nil, errOpenFailed
+	}
+	return f.FileSystem.OpenFile(name, flag, perm)
+}
+
+func TestDeleteIfEqualsWriteError(t *testing.T) {
+	fsys := &failOpenFS{FileSystem: testFS}
+	opts := &Options{FileSystem: fsys}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+
+	// Writing the delete record fails when rotating to a new segment.
+	db.datalog.curSeg.meta.Full = true
+	fsys.fail = true
+	ok, err := db.DeleteIfEquals([]byte{1}, []byte{1})
+	assert.Equal(t, errOpenFailed, err)
+	assert.Equal(t, false, ok)
+	fsys.fail = false
+
+	// The key is still in the index.
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+	assert.Equal(t, uint32(1), db.Count())
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,224 @@
+package pogreb
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// ChangeType is the type of a change.
+type ChangeType int
+
+const (
+	// ChangePut is a change setting the value of a key.
+	ChangePut ChangeType = iota
+	// ChangeDelete is a change deleting a key.
+	ChangeDelete
+	// ChangeMerge is a change merging an operand into the value of a key with Options.MergeOperator.
+	ChangeMerge
+)
+
+// ChangePosition is the position of a change in the write-ahead log.
+// The zero ChangePosition points to the beginning of the write-ahead log.
+type ChangePosition struct {
+	SequenceID uint64 // Sequence ID of the segment holding the change.
+	Offset     uint32 // Offset of the change record in the segment.
+}
+
+// ChangeEvent describes a single modification of the DB.
+type ChangeEvent struct {
+	Type      ChangeType
+	Key       []byte
+	Value     []byte    // Value of put changes or operand of merge changes.
+	ExpiresAt time.Time // Expiration time of the key or zero time if the key doesn't expire.
+	Position  ChangePosition
+}
+
+// changeNotifier wakes up change feeds waiting for new records.
+type changeNotifier struct {
+	c       chan struct{} // Closed when a new record is written.
+	waiting int32         // Set when a change feed is waiting for c.
+	closed  bool
+}
+
+func newChangeNotifier() *changeNotifier {
+	return &changeNotifier{c: make(chan struct{})}
+}
+
+// wait returns the channel closed when a new record is written or the datalog is closed.
+// It's called with the DB read lock held.
+func (n *changeNotifier) wait() <-chan struct{} {
+	atomic.StoreInt32(&n.waiting, 1)
+	return n.c
+}
+
+// notify wakes up change feeds waiting for new records.
+// It's called with the DB write lock held.
+func (n *changeNotifier) notify() {
+	if atomic.LoadInt32(&n.waiting) == 0 {
+		return
+	}
+	atomic.StoreInt32(&n.waiting, 0)
+	close(n.c)
+	n.c = make(chan struct{})
+}
+
+func (n *changeNotifier) close() {
+	if n.closed {
+		return
+	}
+	n.closed = true
+	close(n.c)
+}
+
+// ChangeFeed yields changes written to the DB in the write-ahead log order.
+// Compaction rewrites live records, they are yielded again as put changes holding the current value of the key.
+// Applying the changes in order always results in the state of the DB.
+// ChangeFeed is not safe for concurrent use.
+type ChangeFeed struct {
+	ctx context.Context
+	db  *DB
+	seg *segment // Segment holding the next change, nil if the feed is waiting for the first segment.
+	off int64    // Offset of the next change.
+}
+
+// Subscribe returns a ChangeFeed yielding changes written after the feed was created.
+// The feed stops when ctx is done.
+func (db *DB) Subscribe(ctx context.Context) *ChangeFeed {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	f := &ChangeFeed{ctx: ctx, db: db}
+	segments := db.datalog.segmentsBySequenceID()
+	if len(segments) > 0 {
+		f.seg = segments[len(segments)-1]
+		f.off = f.seg.size
+	}
+	return f
+}
+
+// SubscribeFrom returns a ChangeFeed yielding changes written after the change at the given position.
+// The zero position yields all changes stored in the write-ahead log.
+// It returns ErrPositionCompacted if the segment holding the change was removed by compaction.
+// The feed stops when ctx is done.
+func (db *DB) SubscribeFrom(ctx context.Context, pos ChangePosition) (*ChangeFeed, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	f := &ChangeFeed{ctx: ctx, db: db}
+	if pos == (ChangePosition{}) {
+		return f, nil
+	}
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if seg.sequenceID != pos.SequenceID {
+			continue
+		}
+		if int64(pos.Offset) < int64(headerSize) || int64(pos.Offset) >= seg.size {
+			return nil, &CorruptionError{File: seg.name, Offset: int64(pos.Offset), Reason: "invalid change position"}
+		}
+		rec, err := db.datalog.readRecordAt(seg.id, pos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		f.seg = seg
+		f.off = int64(pos.Offset) + int64(recordSize(uint32(len(rec.key)+len(rec.value)), rec.rtype))
+		return f, nil
+	}
+	return nil, ErrPositionCompacted
+}
+
+// Next returns the next change.
+// It blocks until a change is written to the DB.
+// Next returns the context error when the feed context is done and ErrClosed when the DB is closed.
+func (f *ChangeFeed) Next() (ChangeEvent, error) {
+	for {
+		event, wait, err := f.next()
+		if err != nil {
+			return ChangeEvent{}, err
+		}
+		if wait == nil {
+			return event, nil
+		}
+		select {
+		case <-f.ctx.Done():
+			return ChangeEvent{}, f.ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// next returns the next change or the channel to wait on if no changes are available.
+func (f *ChangeFeed) next() (ChangeEvent, <-chan struct{}, error) {
+	if err := f.ctx.Err(); err != nil {
+		return ChangeEvent{}, nil, err
+	}
+	f.db.mu.RLock()
+	defer f.db.mu.RUnlock()
+	dl := f.db.datalog
+	if dl.changes.closed {
+		return ChangeEvent{}, nil, ErrClosed
+	}
+	for {
+		if f.seg != nil && dl.segments[f.seg.id] != f.seg {
+			return ChangeEvent{}, nil, ErrPositionCompacted
+		}
+		if f.seg != nil && f.off < f.seg.size {
+			pos := ChangePosition{SequenceID: f.seg.sequenceID, Offset: uint32(f.off)}
+			rec, err := dl.readRecordAt(f.seg.id, pos.Offset)
+			if err != nil {
+				return ChangeEvent{}, nil, err
+			}
+			f.off += int64(recordSize(uint32(len(rec.key)+len(rec.value)), rec.rtype))
+			if rec.rtype == recordTypeBatch {
+				// Records of the batch follow the batch record.
+				continue
+			}
+			return newChangeEvent(rec, pos), nil, nil
+		}
+		// Move to the next segment when all changes of the current segment are read.
+		next := dl.nextSegment(f.seg)
+		if next == nil {
+			return ChangeEvent{}, dl.changes.wait(), nil
+		}
+		f.seg = next
+		f.off = int64(headerSize)
+	}
+}
+
+func newChangeEvent(rec record, pos ChangePosition) ChangeEvent {
+	event := ChangeEvent{
+		Key:      cloneBytes(rec.key),
+		Position: pos,
+	}
+	switch rec.rtype {
+	case recordTypeDelete:
+		event.Type = ChangeDelete
+		return event
+	case recordTypeMerge:
+		event.Type = ChangeMerge
+	default:
+		event.Type = ChangePut
+	}
+	event.Value = cloneBytes(rec.value)
+	if expiresAt := rec.expiresAt(); expiresAt != 0 {
+		event.ExpiresAt = time.Unix(0, expiresAt)
+	}
+	return event
+}
+
+// nextSegment returns the oldest segment newer than seg or nil if seg is the newest segment.
+// The oldest segment is returned when seg is nil.
+func (dl *datalog) nextSegment(seg *segment) *segment {
+	var seqID uint64
+	if seg != nil {
+		seqID = seg.sequenceID
+	}
+	var next *segment
+	for _, s := range dl.segments {
+		if s == nil || s.sequenceID <= seqID {
+			continue
+		}
+		if next == nil || s.sequenceID < next.sequenceID {
+			next = s
+		}
+	}
+	return next
+}
@@ -0,0 +1,141 @@
+package pogreb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func nextChanges(t *testing.T, f *ChangeFeed, n int) []ChangeEvent {
+	t.Helper()
+	var events []ChangeEvent
+	for i := 0; i < n; i++ {
+		event, err := f.Next()
+		assert.Nil(t, err)
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestChangeFeed(t *testing.T) {
+	clock := newTestClock()
+	db, err := createTestDB(&Options{FileSystem: testFS, MergeOperator: AppendOperator, now: clock.now})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+	assert.Nil(t, db.Delete([]byte{1}))
+	b := NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{2}, []byte{2}))
+	assert.Nil(t, b.Delete([]byte{3}))
+	assert.Nil(t, db.Write(b))
+	assert.Nil(t, db.PutWithTTL([]byte{4}, []byte{4}, time.Second))
+	assert.Nil(t, db.Merge([]byte{4}, []byte{5}))
+
+	f, err := db.SubscribeFrom(ctx, ChangePosition{})
+	assert.Nil(t, err)
+	events := nextChanges(t, f, 6)
+	expiresAt := clock.now().Add(time.Second)
+	expected := []ChangeEvent{
+		{Type: ChangePut, Key: []byte{1}, Value: []byte{1}},
+		{Type: ChangeDelete, Key: []byte{1}},
+		{Type: ChangePut, Key: []byte{2}, Value: []byte{2}},
+		{Type: ChangeDelete, Key: []byte{3}},
+		{Type: ChangePut, Key: []byte{4}, Value: []byte{4}, ExpiresAt: expiresAt},
+		{Type: ChangeMerge, Key: []byte{4}, Value: []byte{5}, ExpiresAt: expiresAt},
+	}
+	for i := range events {
+		assert.Equal(t, uint64(1), events[i].Position.SequenceID)
+		if i > 0 {
+			assert.Equal(t, true, events[i].Position.Offset > events[i-1].Position.Offset)
+		}
+		expected[i].Position = events[i].Position
+	}
+	assert.Equal(t, expected, events)
+
+	// Resume after the saved position.
+	f, err = db.SubscribeFrom(ctx, events[2].Position)
+	assert.Nil(t, err)
+	assert.Equal(t, expected[3:], nextChanges(t, f, 3))
+
+	_, err = db.SubscribeFrom(ctx, ChangePosition{SequenceID: 2, Offset: 512})
+	assert.Equal(t, ErrPositionCompacted, err)
+
+	assert.Nil(t, db.Close())
+}
+
+func TestChangeFeedLive(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := db.Subscribe(ctx)
+	eventC := make(chan ChangeEvent)
+	errC := make(chan error)
+	go func() {
+		event, err := f.Next()
+		errC <- err
+		eventC <- event
+	}()
+	assert.Nil(t, db.Put([]byte{2}, []byte{2}))
+	assert.Nil(t, <-errC)
+	event := <-eventC
+	assert.Equal(t, []byte{2}, event.Key)
+
+	go func() {
+		_, err := f.Next()
+		errC <- err
+	}()
+	cancel()
+	assert.Equal(t, context.Canceled, <-errC)
+
+	f = db.Subscribe(context.Background())
+	go func() {
+		_, err := f.Next()
+		errC <- err
+	}()
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrClosed, <-errC)
+}
+
+func TestChangeFeedSegments(t *testing.T) {
+	db, err := createTestDB(&Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	})
+	assert.Nil(t, err)
+	ctx := context.Background()
+	f, err := db.SubscribeFrom(ctx, ChangePosition{})
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	assert.Equal(t, true, countSegments(t, db) > 1)
+	events := nextChanges(t, f, 100)
+	for i, event := range events {
+		assert.Equal(t, []byte{byte(i)}, event.Key)
+	}
+	assert.Equal(t, uint64(1), events[0].Position.SequenceID)
+	assert.Equal(t, true, events[99].Position.SequenceID > 1)
+
+	// Compaction removes segments.
+	f, err = db.SubscribeFrom(ctx, events[0].Position)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete([]byte{byte(i)}))
+	}
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, true, cr.CompactedSegments > 0)
+	_, err = f.Next()
+	assert.Equal(t, ErrPositionCompacted, err)
+	_, err = db.SubscribeFrom(ctx, events[0].Position)
+	assert.Equal(t, ErrPositionCompacted, err)
+
+	assert.Nil(t, db.Close())
+}
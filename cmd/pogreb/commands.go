@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akrylysov/pogreb"
+)
+
+// jsonItem is the JSON representation of a key-value pair.
+type jsonItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (c *cmdContext) printJSON(v interface{}) error {
+	return json.NewEncoder(c.out).Encode(v)
+}
+
+func (c *cmdContext) printItem(key []byte, value []byte) error {
+	if c.json {
+		return c.printJSON(jsonItem{Key: c.keyEncoding.encode(key), Value: c.valueEncoding.encode(value)})
+	}
+	_, err := fmt.Fprintf(c.out, "%s\t%s\n", c.keyEncoding.encode(key), c.valueEncoding.encode(value))
+	return err
+}
+
+func runGet(c *cmdContext, args []string) error {
+	key, err := c.keyEncoding.decode(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.db.Get(key)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		// Get returns a non-nil value for existing keys, including empty values.
+		return fmt.Errorf("key %q not found", args[0])
+	}
+	if c.json {
+		return c.printItem(key, value)
+	}
+	_, err = fmt.Fprintln(c.out, c.valueEncoding.encode(value))
+	return err
+}
+
+func runPut(c *cmdContext, args []string) error {
+	key, err := c.keyEncoding.decode(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := c.valueEncoding.decode(args[1])
+	if err != nil {
+		return err
+	}
+	if c.ttl != 0 {
+		return c.db.PutWithTTL(key, value, c.ttl)
+	}
+	return c.db.Put(key, value)
+}
+
+func runDelete(c *cmdContext, args []string) error {
+	key, err := c.keyEncoding.decode(args[0])
+	if err != nil {
+		return err
+	}
+	return c.db.Delete(key)
+}
+
+func runCount(c *cmdContext, _ []string) error {
+	count := c.db.Count()
+	if c.json {
+		return c.printJSON(struct {
+			Count uint32 `json:"count"`
+		}{count})
+	}
+	_, err := fmt.Fprintln(c.out, count)
+	return err
+}
+
+// runDump prints all items in the "key<TAB>value" format or as JSON objects with the -json flag.
+// Keys and values are base64-encoded by default, the raw encoding doesn't round-trip keys and values
+// containing tabs, newlines or invalid UTF-8.
+func runDump(c *cmdContext, _ []string) error {
+	w := bufio.NewWriter(c.out)
+	out := c.out
+	c.out = w
+	defer func() {
+		c.out = out
+	}()
+	it := c.db.Items()
+	for {
+		key, value, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.printItem(key, value); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// runLoad puts items read in the dump format.
+func runLoad(c *cmdContext, _ []string) error {
+	r := bufio.NewReader(c.in)
+	if c.json {
+		dec := json.NewDecoder(r)
+		for {
+			var item jsonItem
+			if err := dec.Decode(&item); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			if err := c.loadItem(item.Key, item.Value); err != nil {
+				return err
+			}
+		}
+	}
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(data) > 0 {
+			data = bytes.TrimSuffix(data, []byte{'\n'})
+			i := bytes.IndexByte(data, '\t')
+			if i == -1 {
+				return fmt.Errorf("line %d: missing tab separator", line)
+			}
+			if err := c.loadItem(string(data[:i]), string(data[i+1:])); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (c *cmdContext) loadItem(encodedKey string, encodedValue string) error {
+	key, err := c.keyEncoding.decode(encodedKey)
+	if err != nil {
+		return err
+	}
+	value, err := c.valueEncoding.decode(encodedValue)
+	if err != nil {
+		return err
+	}
+	return c.db.Put(key, value)
+}
+
+// segmentStats is the JSON representation of pogreb.SegmentStats.
+type segmentStats struct {
+	ID            uint16  `json:"id"`
+	SequenceID    uint64  `json:"sequence_id"`
+	Size          int64   `json:"size"`
+	PutRecords    uint32  `json:"put_records"`
+	DeleteRecords uint32  `json:"delete_records"`
+	MergeRecords  uint32  `json:"merge_records"`
+	DeletedKeys   uint32  `json:"deleted_keys"`
+	DeletedBytes  uint32  `json:"deleted_bytes"`
+	Fragmentation float32 `json:"fragmentation"`
+	Full          bool    `json:"full"`
+
+	ExpiringRecords uint32    `json:"expiring_records"`
+	MinExpiresAt    time.Time `json:"min_expires_at"`
+	MaxExpiresAt    time.Time `json:"max_expires_at"`
+}
+
+// indexStats is the JSON representation of pogreb.IndexStats.
+type indexStats struct {
+	Level               uint8   `json:"level"`
+	NumKeys             uint32  `json:"num_keys"`
+	NumBuckets          uint32  `json:"num_buckets"`
+	SplitBucketIndex    uint32  `json:"split_bucket_index"`
+	OverflowBuckets     int     `json:"overflow_buckets"`
+	FreeOverflowBuckets int     `json:"free_overflow_buckets"`
+	LoadFactor          float64 `json:"load_factor"`
+	LongestChain        int     `json:"longest_chain"`
+}
+
+func runStats(c *cmdContext, _ []string) error {
+	size, err := c.db.FileSize()
+	if err != nil {
+		return err
+	}
+	dbStats, err := c.db.Stats()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		stats := struct {
+			Count    uint32         `json:"count"`
+			FileSize int64          `json:"file_size"`
+			Segments []segmentStats `json:"segments"`
+			Index    indexStats     `json:"index"`
+		}{
+			Count:    c.db.Count(),
+			FileSize: size,
+			Segments: []segmentStats{},
+			Index:    indexStats(dbStats.Index),
+		}
+		for _, seg := range dbStats.Segments {
+			stats.Segments = append(stats.Segments, segmentStats(seg))
+		}
+		return c.printJSON(stats)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	idx := dbStats.Index
+	fmt.Fprintf(w, "count:\t%d\n", c.db.Count())
+	fmt.Fprintf(w, "file size:\t%d\n", size)
+	fmt.Fprintf(w, "index level:\t%d\n", idx.Level)
+	fmt.Fprintf(w, "index buckets:\t%d\n", idx.NumBuckets)
+	fmt.Fprintf(w, "index split bucket:\t%d\n", idx.SplitBucketIndex)
+	fmt.Fprintf(w, "index overflow buckets:\t%d\n", idx.OverflowBuckets)
+	fmt.Fprintf(w, "index free overflow buckets:\t%d\n", idx.FreeOverflowBuckets)
+	fmt.Fprintf(w, "index load factor:\t%.3f\n", idx.LoadFactor)
+	fmt.Fprintf(w, "index longest chain:\t%d\n", idx.LongestChain)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.out)
+	w = tabwriter.NewWriter(c.out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "ID\tSEQUENCE ID\tSIZE\tPUTS\tDELETES\tMERGES\tDELETED KEYS\tDELETED BYTES\tFRAGMENTATION\tFULL\t")
+	for _, seg := range dbStats.Segments {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.3f\t%t\t\n", seg.ID, seg.SequenceID, seg.Size,
+			seg.PutRecords, seg.DeleteRecords, seg.MergeRecords, seg.DeletedKeys, seg.DeletedBytes, seg.Fragmentation, seg.Full)
+	}
+	return w.Flush()
+}
+
+func runCompact(c *cmdContext, _ []string) error {
+	cr, err := c.db.Compact()
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(struct {
+			CompactedSegments int `json:"compacted_segments"`
+			ReclaimedRecords  int `json:"reclaimed_records"`
+			ReclaimedBytes    int `json:"reclaimed_bytes"`
+		}{cr.CompactedSegments, cr.ReclaimedRecords, cr.ReclaimedBytes})
+	}
+	_, err = fmt.Fprintf(c.out, "compacted segments: %d\nreclaimed records: %d\nreclaimed bytes: %d\n",
+		cr.CompactedSegments, cr.ReclaimedRecords, cr.ReclaimedBytes)
+	return err
+}
+
+func runBackup(c *cmdContext, args []string) error {
+	if err := c.db.Backup(args[0]); err != nil {
+		return err
+	}
+	// The backup needs recovery before it can be opened in read-only mode.
+	opts := *c.opts
+	opts.ReadOnly = false
+	db, err := pogreb.Open(args[0], &opts)
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+func runVerify(c *cmdContext, _ []string) error {
+	report, err := c.db.Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	problems := []string{}
+	for _, p := range report.Problems {
+		problems = append(problems, p.Error())
+	}
+	if c.json {
+		err = c.printJSON(struct {
+			Segments int      `json:"segments"`
+			Records  int      `json:"records"`
+			Buckets  int      `json:"buckets"`
+			Keys     int      `json:"keys"`
+			Problems []string `json:"problems"`
+		}{report.Segments, report.Records, report.Buckets, report.Keys, problems})
+	} else {
+		_, err = fmt.Fprintf(c.out, "segments: %d\nrecords: %d\nbuckets: %d\nkeys: %d\n",
+			report.Segments, report.Records, report.Buckets, report.Keys)
+		for _, p := range problems {
+			if err == nil {
+				_, err = fmt.Fprintln(c.out, p)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !report.OK() {
+		return fmt.Errorf("found %d problems", len(report.Problems))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// encoding is an encoding of keys and values in the command arguments and output.
+type encoding string
+
+const (
+	encodingRaw    encoding = "raw"
+	encodingHex    encoding = "hex"
+	encodingBase64 encoding = "base64"
+)
+
+func parseEncoding(s string) (encoding, error) {
+	switch e := encoding(strings.ToLower(s)); e {
+	case encodingRaw, encodingHex, encodingBase64:
+		return e, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", s)
+}
+
+func (e encoding) encode(b []byte) string {
+	switch e {
+	case encodingHex:
+		return hex.EncodeToString(b)
+	case encodingBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+func (e encoding) decode(s string) ([]byte, error) {
+	switch e {
+	case encodingHex:
+		return hex.DecodeString(s)
+	case encodingBase64:
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
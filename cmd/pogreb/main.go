@@ -0,0 +1,219 @@
+/*
+Command pogreb inspects and modifies Pogreb databases.
+
+Usage:
+
+	pogreb <command> [flags] <path> [arguments]
+
+Run "pogreb help" to list the commands.
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/akrylysov/pogreb"
+)
+
+// errUsage is returned when the command is called with invalid arguments, the usage was already printed.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if err != errUsage {
+			fmt.Fprintf(os.Stderr, "pogreb: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// command is a pogreb subcommand.
+type command struct {
+	name     string
+	args     string // Arguments following the database path.
+	help     string
+	nargs    int      // Number of arguments following the database path.
+	readOnly bool     // Open the database in read-only mode.
+	encoding encoding // Default encoding of keys and values, raw if empty.
+	flags    func(fs *flag.FlagSet, c *cmdContext)
+	run      func(c *cmdContext, args []string) error
+}
+
+// cmdContext holds the state of a running command.
+type cmdContext struct {
+	db            *pogreb.DB
+	opts          *pogreb.Options
+	in            io.Reader
+	out           io.Writer
+	keyEncoding   encoding
+	valueEncoding encoding
+	json          bool
+	ttl           time.Duration
+}
+
+var commands = []*command{
+	{
+		name:     "get",
+		args:     "<key>",
+		help:     "Print the value of the key.",
+		nargs:    1,
+		readOnly: true,
+		run:      runGet,
+	},
+	{
+		name:  "put",
+		args:  "<key> <value>",
+		help:  "Set the value of the key.",
+		nargs: 2,
+		flags: func(fs *flag.FlagSet, c *cmdContext) {
+			fs.DurationVar(&c.ttl, "ttl", 0, "expire the key after the `duration`")
+		},
+		run: runPut,
+	},
+	{
+		name:  "delete",
+		args:  "<key>",
+		help:  "Delete the key.",
+		nargs: 1,
+		run:   runDelete,
+	},
+	{
+		name:     "count",
+		help:     "Print the number of keys.",
+		readOnly: true,
+		run:      runCount,
+	},
+	{
+		name:     "dump",
+		help:     "Print all items, one item per line.",
+		readOnly: true,
+		encoding: encodingBase64,
+		run:      runDump,
+	},
+	{
+		name:     "load",
+		help:     "Put items read from the standard input in the dump format.",
+		encoding: encodingBase64,
+		run:      runLoad,
+	},
+	{
+		name:     "stats",
+		help:     "Print the database statistics.",
+		readOnly: true,
+		run:      runStats,
+	},
+	{
+		name: "compact",
+		help: "Compact the database.",
+		run:  runCompact,
+	},
+	{
+		name:     "backup",
+		args:     "<backup path>",
+		help:     "Create a database backup.",
+		nargs:    1,
+		readOnly: true,
+		run:      runBackup,
+	},
+	{
+		name:     "verify",
+		help:     "Check the database integrity.",
+		readOnly: true,
+		run:      runVerify,
+	},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: pogreb <command> [flags] <path> [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.help)
+	}
+	fmt.Fprintf(w, "\nRun \"pogreb <command> -h\" for the command flags.\n")
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		usage(stderr)
+		return errUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "-help" {
+		usage(stdout)
+		return nil
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "pogreb: unknown command %q\n", args[0])
+		usage(stderr)
+		return errUsage
+	}
+
+	c := &cmdContext{
+		in:  stdin,
+		out: stdout,
+	}
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: pogreb %s [flags] <path> %s\n\n%s\n\nFlags:\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	defaultEncoding := string(encodingRaw)
+	if cmd.encoding != "" {
+		defaultEncoding = string(cmd.encoding)
+	}
+	keyEncoding := fs.String("key-encoding", defaultEncoding, "key `encoding`: raw, hex or base64")
+	valueEncoding := fs.String("value-encoding", defaultEncoding, "value `encoding`: raw, hex or base64")
+	mergeOperator := fs.String("merge-operator", "", "merge `operator` used by the database: int64add or append")
+	fs.BoolVar(&c.json, "json", false, "print the output as JSON")
+	if cmd.flags != nil {
+		cmd.flags(fs, c)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return errUsage
+	}
+	if fs.NArg() != cmd.nargs+1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var err error
+	if c.keyEncoding, err = parseEncoding(*keyEncoding); err != nil {
+		return err
+	}
+	if c.valueEncoding, err = parseEncoding(*valueEncoding); err != nil {
+		return err
+	}
+	opts := &pogreb.Options{ReadOnly: cmd.readOnly}
+	switch *mergeOperator {
+	case "":
+	case "int64add":
+		opts.MergeOperator = pogreb.Int64AddOperator
+	case "append":
+		opts.MergeOperator = pogreb.AppendOperator
+	default:
+		return fmt.Errorf("unknown merge operator %q", *mergeOperator)
+	}
+
+	c.opts = opts
+	if c.db, err = pogreb.Open(fs.Arg(0), opts); err != nil {
+		return err
+	}
+	err = cmd.run(c, fs.Args()[1:])
+	if closeErr := c.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+// runCmd runs the command and returns its output.
+func runCmd(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	_, err := runCmd(t, "", "put", path, "k1", "v1")
+	assert.Nil(t, err)
+	_, err = runCmd(t, "", "put", "-key-encoding", "hex", "-value-encoding", "base64", path, "6b32", "djI=")
+	assert.Nil(t, err)
+
+	out, err := runCmd(t, "", "get", path, "k1")
+	assert.Nil(t, err)
+	assert.Equal(t, "v1\n", out)
+
+	out, err = runCmd(t, "", "get", "-value-encoding", "hex", path, "k2")
+	assert.Nil(t, err)
+	assert.Equal(t, "7632\n", out)
+
+	out, err = runCmd(t, "", "get", "-json", path, "k2")
+	assert.Nil(t, err)
+	assert.Equal(t, `{"key":"k2","value":"v2"}`+"\n", out)
+
+	_, err = runCmd(t, "", "get", path, "missing")
+	assert.Equal(t, `key "missing" not found`, err.Error())
+
+	out, err = runCmd(t, "", "count", "-json", path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"count":2}`+"\n", out)
+
+	_, err = runCmd(t, "", "delete", path, "k1")
+	assert.Nil(t, err)
+	out, err = runCmd(t, "", "count", path)
+	assert.Nil(t, err)
+	assert.Equal(t, "1\n", out)
+
+	out, err = runCmd(t, "", "verify", "-json", path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"segments":1,"records":3,"buckets":1,"keys":1,"problems":[]}`+"\n", out)
+
+	out, err = runCmd(t, "", "stats", "-json", path)
+	assert.Nil(t, err)
+	assert.Equal(t, true, strings.Contains(out, `"segments":[{"id":0,"sequence_id":1,"size":552,"put_records":2,"delete_records":1,`))
+	assert.Equal(t, true, strings.Contains(out, `"index":{"level":0,"num_keys":1,"num_buckets":1,`))
+
+	// The segment is below the default compaction thresholds.
+	out, err = runCmd(t, "", "compact", "-json", path)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"compacted_segments":0,"reclaimed_records":0,"reclaimed_bytes":0}`+"\n", out)
+
+	backupPath := filepath.Join(t.TempDir(), "backup.db")
+	_, err = runCmd(t, "", "backup", path, backupPath)
+	assert.Nil(t, err)
+	out, err = runCmd(t, "", "dump", backupPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "azI=\tdjI=\n", out)
+	out, err = runCmd(t, "", "dump", "-key-encoding", "raw", "-value-encoding", "raw", backupPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "k2\tv2\n", out)
+}
+
+func TestRunGet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Empty values are found, expired keys aren't.
+	_, err := runCmd(t, "", "put", path, "empty", "")
+	assert.Nil(t, err)
+	out, err := runCmd(t, "", "get", path, "empty")
+	assert.Nil(t, err)
+	assert.Equal(t, "\n", out)
+	_, err = runCmd(t, "", "put", "-ttl", "1ns", path, "expired", "v")
+	assert.Nil(t, err)
+	_, err = runCmd(t, "", "get", path, "expired")
+	assert.Equal(t, `key "expired" not found`, err.Error())
+}
+
+func TestRunDumpLoad(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.db")
+	dst := filepath.Join(t.TempDir(), "dst.db")
+	for _, kv := range [][]string{{"a", "1"}, {"b", "x\ty\n"}} {
+		_, err := runCmd(t, "", "put", src, kv[0], kv[1])
+		assert.Nil(t, err)
+	}
+
+	for _, flags := range [][]string{
+		{},
+		{"-value-encoding", "hex", "-key-encoding", "hex"},
+		{"-json"},
+	} {
+		t.Run(strings.Join(flags, " "), func(t *testing.T) {
+			dump, err := runCmd(t, "", append(append([]string{"dump"}, flags...), src)...)
+			assert.Nil(t, err)
+			_, err = runCmd(t, dump, append(append([]string{"load"}, flags...), dst)...)
+			assert.Nil(t, err)
+			out, err := runCmd(t, "", "get", dst, "a")
+			assert.Nil(t, err)
+			assert.Equal(t, "1\n", out)
+			out, err = runCmd(t, "", "get", "-json", dst, "b")
+			assert.Nil(t, err)
+			assert.Equal(t, `{"key":"b","value":"x\ty\n"}`+"\n", out)
+		})
+	}
+
+	_, err := runCmd(t, "no tab\n", "load", dst)
+	assert.Equal(t, "line 1: missing tab separator", err.Error())
+}
+
+func TestRunUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	_, err := runCmd(t, "")
+	assert.Equal(t, errUsage, err)
+	_, err = runCmd(t, "", "unknown", path)
+	assert.Equal(t, errUsage, err)
+	_, err = runCmd(t, "", "get", path)
+	assert.Equal(t, errUsage, err)
+	_, err = runCmd(t, "", "get", "-key-encoding", "base32", path, "k")
+	assert.Equal(t, `unknown encoding "base32"`, err.Error())
+	out, err := runCmd(t, "", "help")
+	assert.Nil(t, err)
+	assert.Equal(t, true, strings.HasPrefix(out, "Usage: pogreb"))
+}
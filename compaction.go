@@ -1,23 +1,82 @@
 package pogreb
 
 import (
+	"context"
+	"time"
+
 	"github.com/akrylysov/pogreb/internal/errors"
 )
 
+// errCompactionBudget is returned by compact when the compaction byte budget is exhausted.
+var errCompactionBudget = errors.New("compaction budget exhausted")
+
 // promoteRecord writes the record to the current segment if the index still points to the record.
 // Otherwise it discards the record.
-func (db *DB) promoteRecord(rec record) (bool, error) {
+// Merge records are folded into put records. Merge chains don't span multiple segments, previous records of the merge
+// chain are never pointed to by the index and are discarded.
+// Records expired at the given time are deleted from the index and discarded.
+func (db *DB) promoteRecord(rec record, now int64) (bool, error) {
+	b, i, found, err := db.findRecordSlot(rec)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		// The key was deleted or overwritten. The record is safe to discard.
+		return true, nil
+	}
+	sl := b.slots[i]
+
+	// The record is discarded or moved, the source segment may outlive the compaction if the compaction is stopped.
+	db.datalog.trackDel(sl, rec)
+
+	if rec.expired(now) {
+		// The record is expired, delete it from the index.
+		db.trackSnapshots(rec.key, sl, true)
+		b.del(i)
+		db.index.numKeys--
+		return true, b.write()
+	}
+
+	var segmentID uint16
+	var offset uint32
+	if rec.rtype == recordTypeMerge {
+		// Fold the merge chain into a put record.
+		value, err := db.recordValue(rec)
+		if err != nil {
+			return false, err
+		}
+		segmentID, offset, err = db.datalog.putWithTTL(rec.key, value, rec.expiresAt())
+		if err != nil {
+			return false, err
+		}
+		b.slots[i].valueSize = uint32(len(value))
+	} else {
+		// The record is in the index, write it to the current segment.
+		segmentID, offset, err = db.datalog.writeRecord(rec.data, rec.rtype) // TODO: batch writes
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// Update index.
+	b.slots[i].segmentID = segmentID
+	b.slots[i].offset = offset
+	return false, b.write()
+}
+
+// findRecordSlot finds the index slot pointing to the record.
+// It returns the bucket holding the slot and the slot index, found is false if the record isn't in the index.
+func (db *DB) findRecordSlot(rec record) (b bucketHandle, slotIdx int, found bool, err error) {
 	hash := db.hash(rec.key)
 	it := db.index.newBucketIterator(db.index.bucketIndex(hash))
 	for {
-		b, err := it.next()
+		b, err = it.next()
 		if err == ErrIterationDone {
 			// Exhausted all buckets and the slot wasn't found.
-			// The key was deleted or overwritten. The record is safe to discard.
-			return true, nil
+			return b, 0, false, nil
 		}
 		if err != nil {
-			return false, err
+			return b, 0, false, err
 		}
 		for i := 0; i < slotsPerBucket; i++ {
 			sl := b.slots[i]
@@ -32,16 +91,7 @@ func (db *DB) promoteRecord(rec record) (bool, error) {
 				continue
 			}
 
-			// The record is in the index, write it to the current segment.
-			segmentID, offset, err := db.datalog.writeRecord(rec.data, rec.rtype) // TODO: batch writes
-			if err != nil {
-				return false, err
-			}
-
-			// Update index.
-			b.slots[i].segmentID = segmentID
-			b.slots[i].offset = offset
-			return false, b.write()
+			return b, i, true, nil
 		}
 	}
 }
@@ -53,8 +103,29 @@ type CompactionResult struct {
 	ReclaimedBytes    int
 }
 
-func (db *DB) compact(sourceSeg *segment) (CompactionResult, error) {
+// CompactOptions holds the optional parameters of CompactContext.
+type CompactOptions struct {
+	// MaxSegments limits the number of segments compacted by a single run.
+	//
+	// Default: 0, no limit.
+	MaxSegments int
+
+	// MaxBytes limits the size of segment data processed by a single run.
+	// The compaction stops once the budget is exhausted, leaving the segment being compacted partially compacted.
+	// The remaining records of the segment are compacted by the following runs.
+	//
+	// Default: 0, no limit.
+	MaxBytes int64
+}
+
+// compact copies the live records of the segment to the current segment and removes the segment.
+// It stops between records when the context is canceled or when maxBytes of the segment data are processed, leaving
+// the segment partially compacted. A partially compacted segment remains usable: the index points to the copied records,
+// the segment metadata counts them as deleted.
+// It returns the size of the processed segment data.
+func (db *DB) compact(ctx context.Context, sourceSeg *segment, now int64, maxBytes int64) (CompactionResult, int64, error) {
 	cr := CompactionResult{}
+	var processed int64
 
 	db.mu.Lock()
 	sourceSeg.meta.Full = true // Prevent writes to the compacted file.
@@ -62,63 +133,126 @@ func (db *DB) compact(sourceSeg *segment) (CompactionResult, error) {
 
 	it, err := newSegmentIterator(sourceSeg)
 	if err != nil {
-		return cr, err
+		return cr, processed, err
 	}
 	// Copy records from sourceSeg to the current segment.
 	for {
+		if err := ctx.Err(); err != nil {
+			return cr, processed, err
+		}
+		if maxBytes > 0 && processed >= maxBytes && int64(it.offset) < sourceSeg.size {
+			return cr, processed, errCompactionBudget
+		}
+		var batchBytes int64
 		err := func() error {
 			db.mu.Lock()
 			defer db.mu.Unlock()
-			rec, err := it.next()
-			if err != nil {
-				return err
+			for i := 0; i < db.opts.CompactionBatchSize; i++ {
+				if i > 0 && maxBytes > 0 && processed >= maxBytes {
+					return nil
+				}
+				rec, err := it.next()
+				if err != nil {
+					return err
+				}
+				processed += int64(len(rec.data))
+				batchBytes += int64(len(rec.data))
+				if rec.rtype == recordTypeDelete || rec.rtype == recordTypeBatch {
+					cr.ReclaimedRecords++
+					cr.ReclaimedBytes += len(rec.data)
+					continue
+				}
+				reclaimed, err := db.promoteRecord(rec, now)
+				if reclaimed {
+					cr.ReclaimedRecords++
+					cr.ReclaimedBytes += len(rec.data)
+				}
+				if err != nil {
+					return err
+				}
 			}
-			if rec.rtype == recordTypeDelete {
-				cr.ReclaimedRecords++
-				cr.ReclaimedBytes += len(rec.data)
-				return nil
-			}
-			reclaimed, err := db.promoteRecord(rec)
-			if reclaimed {
-				cr.ReclaimedRecords++
-				cr.ReclaimedBytes += len(rec.data)
-			}
-			return err
+			return nil
 		}()
 		if err == ErrIterationDone {
 			break
 		}
 		if err != nil {
-			return cr, err
+			return cr, processed, err
+		}
+		if db.opts.CompactionRateLimiter != nil {
+			if err := db.opts.CompactionRateLimiter.WaitN(ctx, int(batchBytes)); err != nil {
+				return cr, processed, err
+			}
 		}
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	err = db.datalog.removeSegment(sourceSeg)
-	return cr, err
+	return cr, processed, err
+}
+
+// CompactionPolicy selects segments for compaction.
+//
+// Delete records and expired put records hide put records for the same keys in older segments.
+// When a picked segment contains such records, all older segments are compacted along with it.
+type CompactionPolicy interface {
+	// PickSegments returns IDs of the segments to compact at the given time.
+	// The segments are ordered from oldest to newest, the last one is the segment currently written to.
+	PickSegments(segments []SegmentStats, now time.Time) []uint16
+}
+
+// ThresholdCompactionPolicy is a CompactionPolicy picking segments exceeding the size and fragmentation thresholds.
+type ThresholdCompactionPolicy struct {
+	MinSegmentSize   uint32  // Minimum segment size in bytes.
+	MinFragmentation float32 // Minimum ratio of the deleted and expired records size to the segment size.
 }
 
-// pickForCompaction returns segments eligible for compaction.
-func (db *DB) pickForCompaction() []*segment {
+// PickSegments implements CompactionPolicy.
+func (p ThresholdCompactionPolicy) PickSegments(segments []SegmentStats, _ time.Time) []uint16 {
+	var ids []uint16
+	for _, seg := range segments {
+		if uint32(seg.Size) < p.MinSegmentSize || seg.Fragmentation < p.MinFragmentation {
+			continue
+		}
+		ids = append(ids, seg.ID)
+	}
+	return ids
+}
+
+var _ CompactionPolicy = ThresholdCompactionPolicy{}
+
+// pickForCompaction returns segments eligible for compaction at the given time.
+func (db *DB) pickForCompaction(now int64) []*segment {
 	segments := db.datalog.segmentsBySequenceID()
+	var stats []SegmentStats
+	for _, seg := range segments {
+		if !seg.compacted {
+			stats = append(stats, seg.stats(now))
+		}
+	}
+	selected := make(map[uint16]bool)
+	for _, id := range db.opts.CompactionPolicy.PickSegments(stats, time.Unix(0, now)) {
+		selected[id] = true
+	}
+
 	var picked []*segment
 	for i := len(segments) - 1; i >= 0; i-- {
 		seg := segments[i]
 
-		if uint32(seg.size) < db.opts.compactionMinSegmentSize {
-			continue
-		}
-
-		fragmentation := float32(seg.meta.DeletedBytes) / float32(seg.size)
-		if fragmentation < db.opts.compactionMinFragmentation {
+		if seg.compacted || !selected[seg.id] {
 			continue
 		}
 
-		if seg.meta.DeleteRecords > 0 {
-			// Delete records can be discarded only when older segments contain no put records
+		if seg.meta.hasTombstones(now) {
+			// Delete records and expired put records can be discarded only when older segments contain no put records
 			// for the corresponding keys.
 			// All segments older than the segment eligible for compaction have to be compacted.
+			// Compacted segments referenced by snapshots still hold put records, the segment has to wait until
+			// they are removed.
+			if hasCompacted(segments[:i]) {
+				continue
+			}
 			return append(segments[:i+1], picked...)
 		}
 
@@ -127,31 +261,84 @@ func (db *DB) pickForCompaction() []*segment {
 	return picked
 }
 
-// Compact compacts the DB. Deleted and overwritten items are discarded.
+func hasCompacted(segments []*segment) bool {
+	for _, seg := range segments {
+		if seg.compacted {
+			return true
+		}
+	}
+	return false
+}
+
+// Compact compacts the DB. Deleted, overwritten and expired items are discarded.
 // Returns an error if compaction is already in progress.
 func (db *DB) Compact() (CompactionResult, error) {
-	cr := CompactionResult{}
+	return db.CompactContext(context.Background(), CompactOptions{})
+}
+
+// CompactContext compacts the DB like Compact, stopping early when the context is canceled or when the limits set by
+// CompactOptions are reached.
+// The compaction stops between records, the DB remains consistent and a partially compacted segment remains usable
+// until it's compacted by the following runs. The returned CompactionResult includes only the fully compacted
+// segments. The context error is returned if the context is canceled.
+func (db *DB) CompactContext(ctx context.Context, opts CompactOptions) (CompactionResult, error) {
+	if db.opts.ReadOnly {
+		return CompactionResult{}, ErrReadOnly
+	}
 
 	// Run only a single compaction at a time.
 	if !db.maintenanceMu.TryLock() {
-		return cr, errBusy
+		return CompactionResult{}, ErrBusy
 	}
 	defer func() {
 		db.maintenanceMu.Unlock()
 	}()
 
+	db.opts.EventListener.OnCompactionBegin()
+	cr, err := db.compactSegments(ctx, opts)
+	db.opts.EventListener.OnCompactionEnd(cr, err)
+	return cr, err
+}
+
+// compactSegments compacts segments eligible for compaction within the limits.
+func (db *DB) compactSegments(ctx context.Context, opts CompactOptions) (CompactionResult, error) {
+	cr := CompactionResult{}
+
 	db.mu.RLock()
-	segments := db.pickForCompaction()
+	now := db.now()
+	segments := db.pickForCompaction(now)
 	db.mu.RUnlock()
+	db.metrics.Compactions.Add(1)
 
+	if opts.MaxSegments > 0 && len(segments) > opts.MaxSegments {
+		// Segments are compacted from oldest to newest, segments with tombstones are never compacted before older
+		// segments.
+		segments = segments[:opts.MaxSegments]
+	}
+	var processed int64
 	for _, seg := range segments {
-		segcr, err := db.compact(seg)
+		var maxBytes int64
+		if opts.MaxBytes > 0 {
+			maxBytes = opts.MaxBytes - processed
+			if maxBytes <= 0 {
+				break
+			}
+		}
+		segcr, n, err := db.compact(ctx, seg, now, maxBytes)
+		processed += n
+		if err == errCompactionBudget {
+			break
+		}
+		if err != nil && err == ctx.Err() {
+			return cr, err
+		}
 		if err != nil {
 			return cr, errors.Wrapf(err, "compacting segment %s", seg.name)
 		}
 		cr.CompactedSegments++
 		cr.ReclaimedRecords += segcr.ReclaimedRecords
 		cr.ReclaimedBytes += segcr.ReclaimedBytes
+		db.metrics.ReclaimedBytes.Add(int64(segcr.ReclaimedBytes))
 	}
 
 	return cr, nil
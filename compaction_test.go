@@ -1,6 +1,8 @@
 package pogreb
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -34,9 +36,9 @@ func countSegments(t *testing.T, db *DB) int {
 func TestCompaction(t *testing.T) {
 	run := func(name string, f func(t *testing.T, db *DB)) bool {
 		opts := &Options{
-			maxSegmentSize:             1024,
-			compactionMinSegmentSize:   520,
-			compactionMinFragmentation: 0.02,
+			MaxSegmentSize:             1024,
+			CompactionMinSegmentSize:   520,
+			CompactionMinFragmentation: 0.02,
 		}
 		return t.Run(name, func(t *testing.T) {
 			db, err := createTestDB(opts)
@@ -241,7 +243,7 @@ func TestCompaction(t *testing.T) {
 			return atomic.LoadInt32(&goroutineRunning) == 1
 		})
 		_, err := db.Compact()
-		assert.Equal(t, errBusy, err)
+		assert.Equal(t, ErrBusy, err)
 		db.mu.Unlock()
 		wg.Wait()
 	})
@@ -251,9 +253,9 @@ func TestBackgroundCompaction(t *testing.T) {
 	opts := &Options{
 		BackgroundCompactionInterval: time.Millisecond,
 		BackgroundSyncInterval:       time.Millisecond,
-		maxSegmentSize:               1024,
-		compactionMinSegmentSize:     512,
-		compactionMinFragmentation:   0.2,
+		MaxSegmentSize:               1024,
+		CompactionMinSegmentSize:     512,
+		CompactionMinFragmentation:   0.2,
 	}
 
 	db, err := createTestDB(opts)
@@ -271,3 +273,211 @@ func TestBackgroundCompaction(t *testing.T) {
 
 	assert.Nil(t, db.Close())
 }
+
+func TestCompactContext(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	// A single segment file can fit 42 items (12 bytes per item, 1 byte key, 1 byte value).
+	fill := func(t *testing.T) *DB {
+		db, err := createTestDB(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 84; i++ {
+			assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+		}
+		// Make both segments eligible for compaction without tombstones.
+		for i := 0; i < 84; i += 2 {
+			assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i), byte(i)}))
+		}
+		return db
+	}
+	verify := func(t *testing.T, db *DB) {
+		report, err := db.Verify(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, true, report.OK())
+		for i := 0; i < 84; i++ {
+			v, err := db.Get([]byte{byte(i)})
+			assert.Nil(t, err)
+			if i%2 == 0 {
+				assert.Equal(t, []byte{byte(i), byte(i)}, v)
+			} else {
+				assert.Equal(t, []byte{byte(i)}, v)
+			}
+		}
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		db := fill(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		cr, err := db.CompactContext(ctx, CompactOptions{})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, CompactionResult{}, cr)
+		verify(t, db)
+		assert.Nil(t, db.Close())
+	})
+
+	t.Run("max segments", func(t *testing.T) {
+		db := fill(t)
+		cr, err := db.CompactContext(context.Background(), CompactOptions{MaxSegments: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, CompactionResult{CompactedSegments: 1, ReclaimedRecords: 21, ReclaimedBytes: 252}, cr)
+		assert.Equal(t, false, fileExists(filepath.Join(testDBName, segmentName(0, 1))))
+		assert.Equal(t, true, fileExists(filepath.Join(testDBName, segmentName(1, 2))))
+		verify(t, db)
+		assert.Nil(t, db.Close())
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		db := fill(t)
+		// Stop in the middle of the first segment.
+		cr, err := db.CompactContext(context.Background(), CompactOptions{MaxBytes: 10 * 12})
+		assert.Nil(t, err)
+		assert.Equal(t, CompactionResult{}, cr)
+		assert.Equal(t, true, fileExists(filepath.Join(testDBName, segmentName(0, 1))))
+		verify(t, db)
+
+		// The partially compacted segment survives reopening and recovery.
+		assert.Nil(t, db.Close())
+		db, err = Open(testDBName, opts)
+		assert.Nil(t, err)
+		verify(t, db)
+		assert.Nil(t, db.Close())
+		assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+		db, err = Open(testDBName, opts)
+		assert.Nil(t, err)
+		verify(t, db)
+
+		// Compact the rest of the first segment.
+		cr, err = db.CompactContext(context.Background(), CompactOptions{MaxBytes: 42 * 12})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, cr.CompactedSegments)
+		assert.Equal(t, false, fileExists(filepath.Join(testDBName, segmentName(0, 1))))
+		verify(t, db)
+
+		cr, err = db.Compact()
+		assert.Nil(t, err)
+		assert.Equal(t, true, cr.CompactedSegments > 0)
+		verify(t, db)
+		assert.Nil(t, db.Close())
+	})
+}
+
+// testRateLimiter records the requested sizes.
+type testRateLimiter struct {
+	waits []int
+	err   error
+}
+
+func (l *testRateLimiter) WaitN(_ context.Context, n int) error {
+	l.waits = append(l.waits, n)
+	return l.err
+}
+
+func TestCompactionRateLimit(t *testing.T) {
+	limiter := &testRateLimiter{}
+	opts := &Options{
+		CompactionRateLimiter:      limiter,
+		CompactionBatchSize:        10,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	// A single segment file can fit 42 items (12 bytes per item, 1 byte key, 1 byte value).
+	for i := 0; i < 42; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cr.CompactedSegments)
+	// The limiter is called after every batch of 10 records.
+	assert.Equal(t, []int{120, 120, 120, 120}, limiter.waits)
+
+	// Limiter errors stop the compaction.
+	limiter.waits = nil
+	limiter.err = context.Canceled
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	_, err = db.Compact()
+	assert.Equal(t, true, errors.Is(err, context.Canceled))
+	assert.Equal(t, []int{120}, limiter.waits)
+	assert.Nil(t, db.Close())
+}
+
+type compactionPolicyFunc func(segments []SegmentStats, now time.Time) []uint16
+
+func (f compactionPolicyFunc) PickSegments(segments []SegmentStats, now time.Time) []uint16 {
+	return f(segments, now)
+}
+
+func TestCompactionPolicy(t *testing.T) {
+	var stats []SegmentStats
+	var pick func(segments []SegmentStats) []uint16
+	opts := &Options{
+		MaxSegmentSize: 1024,
+		CompactionPolicy: compactionPolicyFunc(func(segments []SegmentStats, _ time.Time) []uint16 {
+			stats = segments
+			return pick(segments)
+		}),
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	// Fill two segments with the same keys and overwrite some of them in the third segment.
+	for i := 0; i < 2; i++ {
+		for j := byte(0); j < 42; j++ {
+			assert.Nil(t, db.Put([]byte{j}, []byte{j}))
+		}
+	}
+	for j := byte(0); j < 10; j++ {
+		assert.Nil(t, db.Put([]byte{j}, []byte{j}))
+	}
+	assert.Equal(t, 3, countSegments(t, db))
+
+	// Segments below the default thresholds are picked by the policy.
+	pick = func(segments []SegmentStats) []uint16 {
+		return []uint16{segments[1].ID}
+	}
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, CompactionResult{CompactedSegments: 1, ReclaimedRecords: 10, ReclaimedBytes: 120}, cr)
+	assert.Equal(t, 3, len(stats))
+	for i, id := range []uint16{0, 1, 2} {
+		assert.Equal(t, id, stats[i].ID)
+	}
+	assert.Equal(t, uint32(504), stats[0].DeletedBytes)
+	assert.NotNil(t, db.datalog.segments[0])
+	assert.Nil(t, db.datalog.segments[1])
+
+	// Nothing is picked.
+	pick = func([]SegmentStats) []uint16 {
+		return nil
+	}
+	cr, err = db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, CompactionResult{}, cr)
+
+	// Compacting a segment with delete records compacts all older segments.
+	assert.Nil(t, db.Delete([]byte{0}))
+	pick = func(segments []SegmentStats) []uint16 {
+		return []uint16{segments[len(segments)-1].ID}
+	}
+	cr, err = db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, len(stats), cr.CompactedSegments)
+	assert.Equal(t, 1, countSegments(t, db))
+	assert.Equal(t, uint32(41), db.Count())
+	has, err := db.Has([]byte{0})
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	assert.Nil(t, db.Close())
+}
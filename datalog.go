@@ -1,6 +1,7 @@
 package pogreb
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
 	"os"
@@ -22,16 +23,20 @@ type datalog struct {
 	curSeg        *segment
 	segments      [maxSegments]*segment
 	maxSequenceID uint64
+	changes       *changeNotifier
+	metrics       *Metrics
 }
 
-func openDatalog(opts *Options) (*datalog, error) {
+func openDatalog(opts *Options, metrics *Metrics) (*datalog, error) {
 	files, err := opts.FileSystem.ReadDir(".")
 	if err != nil {
 		return nil, err
 	}
 
 	dl := &datalog{
-		opts: opts,
+		opts:    opts,
+		changes: newChangeNotifier(),
+		metrics: metrics,
 	}
 
 	// Open existing segments.
@@ -55,6 +60,11 @@ func openDatalog(opts *Options) (*datalog, error) {
 		dl.segments[seg.id] = seg
 	}
 
+	if opts.ReadOnly {
+		// The read-only datalog has no current segment.
+		return dl, nil
+	}
+
 	if err := dl.swapSegment(); err != nil {
 		return nil, err
 	}
@@ -79,7 +89,7 @@ func parseSegmentName(name string) (uint16, uint64, error) {
 }
 
 func (dl *datalog) openSegment(name string, id uint16, seqID uint64) (*segment, error) {
-	f, err := openFile(dl.opts.FileSystem, name, openFileFlags{})
+	f, err := openFile(dl.opts.FileSystem, name, openFileFlags{readOnly: dl.opts.ReadOnly})
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +98,7 @@ func (dl *datalog) openSegment(name string, id uint16, seqID uint64) (*segment,
 	if !f.empty() {
 		metaName := name + metaExt
 		if err := readGobFile(dl.opts.FileSystem, metaName, &meta); err != nil {
-			logger.Printf("error reading segment meta %d: %v", id, err)
+			dl.opts.Logger.Error("error reading segment meta", "segment", name, "err", err)
 			// TODO: rebuild meta?
 		}
 	}
@@ -142,7 +152,14 @@ func (dl *datalog) swapSegment() error {
 	return nil
 }
 
+// removeSegment removes the segment.
+// Removing a referenced segment is postponed until the last reference to the segment is released.
 func (dl *datalog) removeSegment(seg *segment) error {
+	if seg.refs > 0 {
+		seg.compacted = true
+		return nil
+	}
+
 	dl.segments[seg.id] = nil
 
 	if err := seg.Close(); err != nil {
@@ -160,30 +177,183 @@ func (dl *datalog) removeSegment(seg *segment) error {
 		return err
 	}
 
+	dl.changes.notify()
+
+	return nil
+}
+
+// openReplicaSegment makes the segment replicated from the leader current, creating the segment if necessary.
+// Replicated segments keep the physical and the sequence identifiers of the leader segments.
+func (dl *datalog) openReplicaSegment(id uint16, seqID uint64) error {
+	seg := dl.segments[id]
+	if seg == nil {
+		var err error
+		seg, err = dl.openSegment(segmentName(id, seqID), id, seqID)
+		if err != nil {
+			return err
+		}
+		dl.segments[id] = seg
+		if seqID > dl.maxSequenceID {
+			dl.maxSequenceID = seqID
+		}
+	}
+	if dl.curSeg != nil && dl.curSeg != seg {
+		dl.curSeg.meta.Full = true
+	}
+	dl.curSeg = seg
+	return nil
+}
+
+// removeReplicaSegment removes the segment removed by the leader.
+func (dl *datalog) removeReplicaSegment(seg *segment) error {
+	if err := dl.removeSegment(seg); err != nil {
+		return err
+	}
+	if seg != dl.curSeg {
+		return nil
+	}
+	// Make the newest segment current.
+	dl.curSeg = nil
+	segments := dl.segmentsBySequenceID()
+	for i := len(segments) - 1; i >= 0; i-- {
+		if !segments[i].compacted {
+			dl.curSeg = segments[i]
+			return nil
+		}
+	}
+	return dl.swapSegment()
+}
+
+// recordsEnd returns the end offset of the records starting at the given segment offset.
+// Records are read until the end of the segment or until the given size is reached.
+// A batch is never split.
+func (dl *datalog) recordsEnd(seg *segment, off int64, size int64) (int64, error) {
+	end := off
+	for end < seg.size && end-off < size {
+		rec, err := dl.readRecordAt(seg.id, uint32(end))
+		if err != nil {
+			return 0, err
+		}
+		end += int64(recordSize(uint32(len(rec.key)+len(rec.value)), rec.rtype))
+		if rec.rtype != recordTypeBatch {
+			continue
+		}
+		for i := uint32(0); i < rec.batchSize(); i++ {
+			brec, err := dl.readRecordAt(seg.id, uint32(end))
+			if err != nil {
+				return 0, err
+			}
+			end += int64(recordSize(uint32(len(brec.key)+len(brec.value)), brec.rtype))
+		}
+	}
+	return end, nil
+}
+
+// ref prevents the segment from being removed until the reference is released.
+func (dl *datalog) ref(segmentID uint16) {
+	dl.segments[segmentID].refs++
+}
+
+// unref releases the segment reference and removes the segment if it was compacted.
+func (dl *datalog) unref(segmentID uint16) error {
+	seg := dl.segments[segmentID]
+	seg.refs--
+	if seg.refs == 0 && seg.compacted {
+		return dl.removeSegment(seg)
+	}
 	return nil
 }
 
-func (dl *datalog) readKeyValue(sl slot) ([]byte, []byte, error) {
-	off := int64(sl.offset) + 6 // Skip key size and value size.
+// readRecord reads the record the slot points to.
+// The record value is read only when withValue is true.
+// The returned record doesn't hold the encoded record data.
+func (dl *datalog) readRecord(sl slot, withValue bool) (record, error) {
+	off := int64(sl.offset)
+	end := off + 6 + int64(sl.keySize)
+	if withValue {
+		end += int64(sl.valueSize)
+	}
 	seg := dl.segments[sl.segmentID]
-	keyValue, err := seg.Slice(off, off+int64(sl.kvSize()))
+	data, err := seg.Slice(off, end)
+	if err != nil {
+		return record{}, err
+	}
+	rec := record{
+		rtype:     recordTypePut,
+		segmentID: sl.segmentID,
+		offset:    sl.offset,
+		key:       data[6 : 6+sl.keySize],
+	}
+	if withValue {
+		rec.value = data[6+sl.keySize:]
+	}
+	if binary.LittleEndian.Uint32(data[2:6])&extendedBit == 0 {
+		return rec, nil
+	}
+	// Read the record type and the type-specific data of the extended record.
+	typeOff := off + 6 + int64(sl.kvSize())
+	rt, err := seg.Slice(typeOff, typeOff+1)
 	if err != nil {
-		return nil, nil, err
+		return record{}, err
 	}
-	return keyValue[:sl.keySize], keyValue[sl.keySize:], nil
+	rec.rtype = recordType(rt[0])
+	extraSize, ok := rec.rtype.extraSize()
+	if !ok {
+		return record{}, &CorruptionError{File: seg.name, Offset: off, Reason: "unknown record type"}
+	}
+	if rec.extra, err = seg.Slice(typeOff+1, typeOff+1+int64(extraSize)); err != nil {
+		return record{}, err
+	}
+	return rec, nil
 }
 
-func (dl *datalog) readKey(sl slot) ([]byte, error) {
-	off := int64(sl.offset) + 6
-	seg := dl.segments[sl.segmentID]
-	return seg.Slice(off, off+int64(sl.keySize))
+// lookupRecord reads the record the slot points to on behalf of a read operation and counts the read bytes.
+func (dl *datalog) lookupRecord(sl slot, withValue bool) (record, error) {
+	rec, err := dl.readRecord(sl, withValue)
+	if err != nil {
+		return record{}, err
+	}
+	n := 6 + int64(sl.keySize)
+	if withValue {
+		n += int64(sl.valueSize)
+	}
+	dl.metrics.BytesRead.Add(n)
+	return rec, nil
+}
+
+// readRecordAt reads the record at the given segment offset.
+func (dl *datalog) readRecordAt(segmentID uint16, offset uint32) (record, error) {
+	seg := dl.segments[segmentID]
+	if seg == nil {
+		return record{}, &CorruptionError{File: segmentName(segmentID, 0), Offset: int64(offset), Reason: "missing segment"}
+	}
+	buf, err := seg.Slice(int64(offset), int64(offset)+6)
+	if err != nil {
+		return record{}, err
+	}
+	valueSize := binary.LittleEndian.Uint32(buf[2:6])
+	sl := slot{
+		segmentID: segmentID,
+		keySize:   binary.LittleEndian.Uint16(buf[:2]),
+		valueSize: valueSize &^ (deleteBit | extendedBit),
+		offset:    offset,
+	}
+	rec, err := dl.readRecord(sl, true)
+	if err == nil && valueSize&deleteBit != 0 {
+		rec.rtype = recordTypeDelete
+	}
+	return rec, err
 }
 
 // trackDel updates segment's metadata for deleted or overwritten items.
-func (dl *datalog) trackDel(sl slot) {
+func (dl *datalog) trackDel(sl slot, rec record) {
 	meta := dl.segments[sl.segmentID].meta
+	size := recordSize(sl.kvSize(), rec.rtype)
 	meta.DeletedKeys++
-	meta.DeletedBytes += encodedRecordSize(sl.kvSize())
+	meta.DeletedBytes += size
+	if rec.expiresAt() != 0 {
+		meta.ExpiringBytes -= size
+	}
 }
 
 func (dl *datalog) del(key []byte) error {
@@ -197,11 +367,26 @@ func (dl *datalog) del(key []byte) error {
 	return nil
 }
 
-func (dl *datalog) writeRecord(data []byte, rt recordType) (uint16, uint32, error) {
-	if dl.curSeg.meta.Full || dl.curSeg.size+int64(len(data)) > int64(dl.opts.maxSegmentSize) {
+// swapSegmentIfFull swaps the current segment when it can't fit size more bytes.
+func (dl *datalog) swapSegmentIfFull(size int) error {
+	if dl.curSeg.meta.Full || dl.curSeg.size+int64(size) > int64(dl.opts.MaxSegmentSize) {
 		// Current segment is full, create a new one.
-		dl.curSeg.meta.Full = true
+		fullSeg := dl.curSeg
+		fullSeg.meta.Full = true
 		if err := dl.swapSegment(); err != nil {
+			return err
+		}
+		dl.opts.EventListener.OnSegmentRotated(fullSeg.name, dl.curSeg.name)
+	}
+	return nil
+}
+
+func (dl *datalog) writeRecord(data []byte, rt recordType) (uint16, uint32, error) {
+	if err := dl.swapSegmentIfFull(len(data)); err != nil {
+		return 0, 0, err
+	}
+	if _, extended := rt.extraSize(); extended {
+		if err := dl.curSeg.upgradeHeader(); err != nil {
 			return 0, 0, err
 		}
 	}
@@ -209,12 +394,22 @@ func (dl *datalog) writeRecord(data []byte, rt recordType) (uint16, uint32, erro
 	if err != nil {
 		return 0, 0, err
 	}
+	dl.metrics.BytesWritten.Add(int64(len(data)))
 	switch rt {
 	case recordTypePut:
 		dl.curSeg.meta.PutRecords++
+	case recordTypePutTTL:
+		dl.curSeg.meta.PutRecords++
+		dl.curSeg.meta.trackExpiring(uint32(len(data)), decodeExpiresAt(data))
+	case recordTypeMerge:
+		dl.curSeg.meta.MergeRecords++
+		if expiresAt := decodeExpiresAt(data); expiresAt != 0 {
+			dl.curSeg.meta.trackExpiring(uint32(len(data)), expiresAt)
+		}
 	case recordTypeDelete:
 		dl.curSeg.meta.DeleteRecords++
 	}
+	dl.changes.notify()
 	return dl.curSeg.id, uint32(off), nil
 }
 
@@ -222,11 +417,110 @@ func (dl *datalog) put(key []byte, value []byte) (uint16, uint32, error) {
 	return dl.writeRecord(encodePutRecord(key, value), recordTypePut)
 }
 
+// putWithTTL writes a put record with expiration time or a regular put record when expiresAt is 0.
+func (dl *datalog) putWithTTL(key []byte, value []byte, expiresAt int64) (uint16, uint32, error) {
+	if expiresAt == 0 {
+		return dl.put(key, value)
+	}
+	return dl.writeRecord(encodePutTTLRecord(key, value, expiresAt), recordTypePutTTL)
+}
+
+func (dl *datalog) merge(key []byte, operand []byte, prev slot, depth uint16, expiresAt int64) (uint16, uint32, error) {
+	return dl.writeRecord(encodeMergeRecord(key, operand, prev, depth, expiresAt), recordTypeMerge)
+}
+
+// addStreamSegment turns the staging file holding a single put record into the current segment.
+// The segment gets the highest sequence ID, recovery replays the record after all records written before it.
+// The staging file is closed, it is removed if it can't be added.
+func (dl *datalog) addStreamSegment(staged *file, stagedName string) (uint16, uint32, error) {
+	size := staged.size
+	fsys := dl.opts.FileSystem
+	if err := staged.Close(); err != nil {
+		_ = fsys.Remove(stagedName)
+		return 0, 0, err
+	}
+	id, seqID, err := dl.nextWritableSegmentID()
+	if err != nil {
+		_ = fsys.Remove(stagedName)
+		return 0, 0, err
+	}
+	name := segmentName(id, seqID)
+	if err := fsys.Rename(stagedName, name); err != nil {
+		_ = fsys.Remove(stagedName)
+		return 0, 0, err
+	}
+	f, err := openFile(fsys, name, openFileFlags{})
+	if err != nil {
+		_ = fsys.Remove(name)
+		return 0, 0, err
+	}
+	seg := &segment{
+		file:       f,
+		id:         id,
+		sequenceID: seqID,
+		name:       name,
+		meta:       &segmentMeta{PutRecords: 1},
+	}
+	fullSeg := dl.curSeg
+	fullSeg.meta.Full = true
+	dl.segments[id] = seg
+	dl.curSeg = seg
+	dl.opts.EventListener.OnSegmentRotated(fullSeg.name, seg.name)
+	dl.metrics.BytesWritten.Add(size - int64(headerSize))
+	dl.changes.notify()
+	return id, uint32(headerSize), nil
+}
+
+// canAppend returns true if the record of the given size can be written to the segment without swapping segments.
+func (dl *datalog) canAppend(segmentID uint16, size uint32) bool {
+	seg := dl.curSeg
+	return seg.id == segmentID && !seg.meta.Full && seg.size+int64(size) <= int64(dl.opts.MaxSegmentSize)
+}
+
+// writeBatch writes the batch record followed by the batch records to the current segment with a single write.
+// It returns the offset of the first batch record.
+func (dl *datalog) writeBatch(b *WriteBatch) (uint16, uint32, error) {
+	rec := encodeBatchRecord(uint32(len(b.ops)))
+	data := make([]byte, 0, len(rec)+len(b.data))
+	data = append(data, rec...)
+	data = append(data, b.data...)
+	if int64(len(data)) > math.MaxUint32-int64(headerSize) {
+		return 0, 0, ErrBatchTooLarge
+	}
+	if err := dl.swapSegmentIfFull(len(data)); err != nil {
+		return 0, 0, err
+	}
+	if err := dl.curSeg.upgradeHeader(); err != nil {
+		return 0, 0, err
+	}
+	off, err := dl.curSeg.append(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	dl.metrics.BytesWritten.Add(int64(len(data)))
+	meta := dl.curSeg.meta
+	for _, op := range b.ops {
+		switch op.rtype {
+		case recordTypePut:
+			meta.PutRecords++
+		case recordTypeDelete:
+			meta.DeleteRecords++
+			// Compaction removes delete records, increment DeletedBytes.
+			meta.DeletedBytes += encodedRecordSize(uint32(op.keySize))
+		}
+	}
+	// Compaction removes batch records, increment DeletedBytes.
+	meta.DeletedBytes += uint32(len(rec))
+	dl.changes.notify()
+	return dl.curSeg.id, uint32(off) + uint32(len(rec)), nil
+}
+
 func (dl *datalog) sync() error {
 	return dl.curSeg.Sync()
 }
 
 func (dl *datalog) close() error {
+	dl.changes.close()
 	for _, seg := range dl.segments {
 		if seg == nil {
 			continue
@@ -234,6 +528,9 @@ func (dl *datalog) close() error {
 		if err := seg.Close(); err != nil {
 			return err
 		}
+		if dl.opts.ReadOnly {
+			continue
+		}
 		metaName := seg.name + metaExt
 		if err := writeGobFile(dl.opts.FileSystem, metaName, seg.meta); err != nil {
 			return err
@@ -1,7 +1,10 @@
 package pogreb
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/akrylysov/pogreb/internal/assert"
 )
@@ -43,3 +46,53 @@ func TestDatalog(t *testing.T) {
 
 	assert.Nil(t, db.Close())
 }
+
+func TestDatalogUpgradeHeader(t *testing.T) {
+	opts := &Options{FileSystem: testFS}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+	assert.Nil(t, db.Close())
+
+	segName := filepath.Join(testDBName, segmentName(0, 1))
+	readVersion := func() uint32 {
+		t.Helper()
+		f, err := testFS.OpenFile(segName, os.O_RDONLY, 0)
+		assert.Nil(t, err)
+		buf := make([]byte, headerSize)
+		_, err = f.ReadAt(buf, 0)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+		h := &header{}
+		assert.Nil(t, h.UnmarshalBinary(buf))
+		return h.formatVersion
+	}
+
+	// Simulate a segment written by version 2.
+	f, err := testFS.OpenFile(segName, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{2, 0, 0, 0}, 8)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	// Records readable by version 2 keep the header.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{2}, []byte{2}))
+	assert.Nil(t, db.Delete([]byte{2}))
+	assert.Nil(t, db.Sync())
+	assert.Equal(t, uint32(2), readVersion())
+
+	// The header is upgraded before writing the first extended record.
+	assert.Nil(t, db.PutWithTTL([]byte{3}, []byte{3}, time.Hour))
+	assert.Nil(t, db.Sync())
+	assert.Equal(t, uint32(formatVersion), readVersion())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	v, err := db.Get([]byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, v)
+	assert.Nil(t, db.Close())
+}
@@ -41,6 +41,11 @@ type DB struct {
 	cancelBgWorker context.CancelFunc
 	closeWg        sync.WaitGroup
 	maintenanceMu  sync.Mutex // Ensures there only one maintenance task running at a time.
+	following      bool       // Follow is running, local writes are rejected.
+	stagingSeq     uint64     // Sequence number of the last PutReader staging file.
+	snapshots      map[*Snapshot]struct{}
+	valueReadersMu sync.Mutex // Guards valueReaders and segment references taken under the DB read lock.
+	valueReaders   map[*valueReader]struct{}
 }
 
 type dbMeta struct {
@@ -50,47 +55,76 @@ type dbMeta struct {
 // Open opens or creates a new DB.
 // The DB must be closed after use, by calling Close method.
 func Open(path string, opts *Options) (*DB, error) {
-	opts = opts.copyWithDefaults(path)
-
-	if err := opts.rootFS.MkdirAll(path, 0755); err != nil {
+	opts, err := opts.copyWithDefaults(path)
+	if err != nil {
 		return nil, err
 	}
 
+	if !opts.ReadOnly {
+		if err := opts.rootFS.MkdirAll(path, 0755); err != nil {
+			return nil, err
+		}
+	}
+
 	// Try to acquire a file lock.
 	lock, acquiredExistingLock, err := createLockFile(opts)
+	memIndex := false
+	if err == os.ErrExist && opts.ReadOnly {
+		// The DB is open in read-write mode, the index on disk is being modified.
+		// Open the DB without the lock and rebuild the index from the segments in memory.
+		// The segments aren't memory-mapped, the read-write instance may truncate or remove them.
+		memIndex = true
+		err = nil
+		if m, ok := opts.FileSystem.(fs.Mmapper); ok {
+			opts.FileSystem = m.WithoutMmap()
+		}
+	}
 	if err != nil {
 		if err == os.ErrExist {
-			err = errLocked
+			err = ErrLocked
 		}
 		return nil, errors.Wrap(err, "creating lock file")
 	}
 
+	if acquiredExistingLock && opts.ReadOnly {
+		// The read-only database can't be recovered.
+		_ = lock.Unlock()
+		return nil, ErrNeedsRecovery
+	}
+
 	if acquiredExistingLock {
 		// Lock file already existed, but the process managed to acquire it.
 		// It means the database wasn't closed properly.
 		// Start recovery process.
-		if err := backupNonsegmentFiles(opts.FileSystem); err != nil {
+		if err := backupNonsegmentFiles(opts); err != nil {
 			return nil, err
 		}
 	}
 
-	index, err := openIndex(opts)
+	indexOpts := opts
+	if memIndex {
+		indexOpts = opts.copyForMemIndex()
+	}
+	index, err := openIndex(indexOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "opening index")
 	}
 
-	datalog, err := openDatalog(opts)
+	metrics := &Metrics{}
+	datalog, err := openDatalog(opts, metrics)
 	if err != nil {
 		return nil, errors.Wrap(err, "opening datalog")
 	}
 
 	db := &DB{
-		opts:       opts,
-		index:      index,
-		datalog:    datalog,
-		lock:       lock,
-		metrics:    &Metrics{},
-		syncWrites: opts.BackgroundSyncInterval == -1,
+		opts:         opts,
+		index:        index,
+		datalog:      datalog,
+		lock:         lock,
+		metrics:      metrics,
+		syncWrites:   opts.BackgroundSyncInterval == -1,
+		snapshots:    make(map[*Snapshot]struct{}),
+		valueReaders: make(map[*valueReader]struct{}),
 	}
 	if index.count() == 0 {
 		// The index is empty, make a new hash seed.
@@ -109,9 +143,16 @@ func Open(path string, opts *Options) (*DB, error) {
 		if err := db.recover(); err != nil {
 			return nil, errors.Wrap(err, "recovering")
 		}
+		db.metrics.Recoveries.Add(1)
+	}
+
+	if memIndex {
+		if err := db.rebuildIndex(); err != nil {
+			return nil, errors.Wrap(err, "rebuilding index")
+		}
 	}
 
-	if db.opts.BackgroundSyncInterval > 0 || db.opts.BackgroundCompactionInterval > 0 {
+	if !opts.ReadOnly && (db.opts.BackgroundSyncInterval > 0 || db.opts.BackgroundCompactionInterval > 0) {
 		db.startBackgroundWorker()
 	}
 
@@ -174,37 +215,48 @@ func (db *DB) startBackgroundWorker() {
 				return
 			case <-syncC:
 				if err := db.Sync(); err != nil {
-					logger.Printf("error synchronizing database: %v", err)
+					db.opts.Logger.Error("error synchronizing database", "err", err)
+					db.opts.EventListener.OnBackgroundError(err)
 				}
 			case <-compactC:
-				if cr, err := db.Compact(); err != nil {
-					logger.Printf("error compacting database: %v", err)
+				if cr, err := db.CompactContext(ctx, CompactOptions{}); err != nil {
+					if ctx.Err() != nil {
+						// The DB is closing.
+						return
+					}
+					db.opts.Logger.Error("error compacting database", "err", err)
+					db.opts.EventListener.OnBackgroundError(err)
 				} else if cr.CompactedSegments > 0 {
-					logger.Printf("compacted database: %+v", cr)
+					db.opts.Logger.Info("compacted database", "compacted_segments", cr.CompactedSegments,
+						"reclaimed_records", cr.ReclaimedRecords, "reclaimed_bytes", cr.ReclaimedBytes)
 				}
 			}
 		}
 	}()
 }
 
-// Get returns the value for the given key stored in the DB or nil if the key doesn't exist.
-func (db *DB) Get(key []byte) ([]byte, error) {
-	h := db.hash(key)
-	db.metrics.Gets.Add(1)
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+// now returns the current time in Unix nanoseconds used to check expiration of keys.
+func (db *DB) now() int64 {
+	return db.opts.now().UnixNano()
+}
+
+// get returns the value for the given key or nil if the key doesn't exist or is expired at the given time.
+// The returned value is valid only while the DB read lock is held.
+func (db *DB) get(h uint32, key []byte, now int64) ([]byte, error) {
 	var retValue []byte
 	err := db.index.get(h, func(sl slot) (bool, error) {
 		if uint16(len(key)) != sl.keySize {
 			return false, nil
 		}
-		slKey, value, err := db.datalog.readKeyValue(sl)
+		rec, err := db.datalog.lookupRecord(sl, true)
 		if err != nil {
 			return true, err
 		}
-		if bytes.Equal(key, slKey) {
-			retValue = cloneBytes(value)
-			return true, nil
+		if bytes.Equal(key, rec.key) {
+			if !rec.expired(now) {
+				retValue, err = db.recordValue(rec)
+			}
+			return true, err
 		}
 		db.metrics.HashCollisions.Add(1)
 		return false, nil
@@ -215,51 +267,143 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return retValue, nil
 }
 
+// Get returns the value for the given key stored in the DB or nil if the key doesn't exist.
+func (db *DB) Get(key []byte) ([]byte, error) {
+	defer db.metrics.GetLatency.observeSince(time.Now())
+	h := db.hash(key)
+	db.metrics.Gets.Add(1)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	value, err := db.get(h, key, db.now())
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return cloneBytes(value), nil
+}
+
 // GetAppend returns the value for the given key (appended into buffer) stored in the DB or nil if the key doesn't exist
 func (db *DB) GetAppend(key, buf []byte) ([]byte, error) {
+	defer db.metrics.GetLatency.observeSince(time.Now())
 	h := db.hash(key)
 	db.metrics.Gets.Add(1)
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	var retValue []byte
-	err := db.index.get(h, func(sl slot) (bool, error) {
+	value, err := db.get(h, key, db.now())
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return append(buf, value...), nil
+}
+
+// View calls fn with the value for the given key stored in the DB or nil if the key doesn't exist.
+// The value isn't copied: with the memory-mapped file system it points directly to the mapped segment file,
+// with fs.OS it's read into a new buffer.
+// The value is valid only inside fn and must not be modified, copy it to use it after fn returns.
+// View holds the DB read lock while fn runs, calling methods modifying the DB inside fn causes a deadlock.
+// The error returned by fn is returned by View.
+func (db *DB) View(key []byte, fn func(value []byte) error) error {
+	defer db.metrics.GetLatency.observeSince(time.Now())
+	h := db.hash(key)
+	db.metrics.Gets.Add(1)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	value, err := db.get(h, key, db.now())
+	if err != nil {
+		return err
+	}
+	return fn(value)
+}
+
+// getMany calls fn with the value for each key found in the DB and not expired at the given time.
+// The values are valid only while the DB read lock is held.
+func (db *DB) getMany(hashes []uint32, keys [][]byte, now int64, fn func(int, []byte)) error {
+	return db.index.getMany(hashes, func(i int, sl slot) (bool, error) {
+		key := keys[i]
 		if uint16(len(key)) != sl.keySize {
 			return false, nil
 		}
-		slKey, value, err := db.datalog.readKeyValue(sl)
+		rec, err := db.datalog.lookupRecord(sl, true)
 		if err != nil {
 			return true, err
 		}
-		if bytes.Equal(key, slKey) {
-			retValue = append(buf, value...)
+		if bytes.Equal(key, rec.key) {
+			if !rec.expired(now) {
+				value, err := db.recordValue(rec)
+				if err != nil {
+					return true, err
+				}
+				fn(i, value)
+			}
 			return true, nil
 		}
 		db.metrics.HashCollisions.Add(1)
 		return false, nil
 	})
+}
+
+func (db *DB) hashKeys(keys [][]byte) []uint32 {
+	hashes := make([]uint32, len(keys))
+	for i, key := range keys {
+		hashes[i] = db.hash(key)
+	}
+	return hashes
+}
+
+// GetMany returns the values for the given keys stored in the DB.
+// The value is nil for keys that don't exist.
+// GetMany holds the DB read lock once for all keys and reads each index bucket once for keys landing in the same
+// bucket, it's faster than calling Get for each key.
+func (db *DB) GetMany(keys [][]byte) ([][]byte, error) {
+	defer db.metrics.GetManyLatency.observeSince(time.Now())
+	hashes := db.hashKeys(keys)
+	db.metrics.Gets.Add(int64(len(keys)))
+	values := make([][]byte, len(keys))
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	err := db.getMany(hashes, keys, db.now(), func(i int, value []byte) {
+		values[i] = cloneBytes(value)
+	})
 	if err != nil {
 		return nil, err
 	}
-	return retValue, nil
+	return values, nil
 }
 
-// Has returns true if the DB contains the given key.
-func (db *DB) Has(key []byte) (bool, error) {
-	h := db.hash(key)
-	db.metrics.Gets.Add(1)
-	found := false
+// GetManyAppend returns the values for the given keys (appended into the corresponding buffers) stored in the DB.
+// The value is nil for keys that don't exist.
+// bufs[i] is the buffer for keys[i], bufs can be shorter than keys, missing buffers are treated as nil.
+func (db *DB) GetManyAppend(keys [][]byte, bufs [][]byte) ([][]byte, error) {
+	defer db.metrics.GetManyLatency.observeSince(time.Now())
+	hashes := db.hashKeys(keys)
+	db.metrics.Gets.Add(int64(len(keys)))
+	values := make([][]byte, len(keys))
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	err := db.getMany(hashes, keys, db.now(), func(i int, value []byte) {
+		var buf []byte
+		if i < len(bufs) {
+			buf = bufs[i]
+		}
+		values[i] = append(buf, value...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (db *DB) has(h uint32, key []byte, now int64) (bool, error) {
+	found := false
 	err := db.index.get(h, func(sl slot) (bool, error) {
 		if uint16(len(key)) != sl.keySize {
 			return false, nil
 		}
-		slKey, err := db.datalog.readKey(sl)
+		rec, err := db.datalog.lookupRecord(sl, false)
 		if err != nil {
 			return true, err
 		}
-		if bytes.Equal(key, slKey) {
-			found = true
+		if bytes.Equal(key, rec.key) {
+			found = !rec.expired(now)
 			return true, nil
 		}
 		return false, nil
@@ -270,37 +414,61 @@ func (db *DB) Has(key []byte) (bool, error) {
 	return found, nil
 }
 
+// Has returns true if the DB contains the given key.
+func (db *DB) Has(key []byte) (bool, error) {
+	defer db.metrics.GetLatency.observeSince(time.Now())
+	h := db.hash(key)
+	db.metrics.Gets.Add(1)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.has(h, key, db.now())
+}
+
 func (db *DB) put(sl slot, key []byte) error {
-	return db.index.put(sl, func(cursl slot) (bool, error) {
+	found := false
+	err := db.index.put(sl, func(cursl slot) (bool, error) {
 		if uint16(len(key)) != cursl.keySize {
 			return false, nil
 		}
-		slKey, err := db.datalog.readKey(cursl)
+		rec, err := db.datalog.readRecord(cursl, false)
 		if err != nil {
 			return true, err
 		}
-		if bytes.Equal(key, slKey) {
-			db.datalog.trackDel(cursl) // Overwriting existing key.
+		if bytes.Equal(key, rec.key) {
+			db.trackSnapshots(key, cursl, true)
+			db.datalog.trackDel(cursl, rec) // Overwriting existing key.
+			found = true
 			return true, nil
 		}
 		return false, nil
 	})
+	if err == nil && !found {
+		db.trackSnapshots(key, slot{hash: sl.hash}, false)
+	}
+	return err
 }
 
-// Put sets the value for the given key. It updates the value for the existing key.
-func (db *DB) Put(key []byte, value []byte) error {
+// putValue writes a put record with an optional expiration time and updates the index.
+func (db *DB) putValue(key []byte, value []byte, expiresAt int64) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
 	if len(key) > MaxKeyLength {
-		return errKeyTooLarge
+		return ErrKeyTooLarge
 	}
 	if len(value) > MaxValueLength {
-		return errValueTooLarge
+		return ErrValueTooLarge
 	}
+	defer db.metrics.PutLatency.observeSince(time.Now())
 	h := db.hash(key)
 	db.metrics.Puts.Add(1)
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if db.following {
+		return ErrReadOnly
+	}
 
-	segID, offset, err := db.datalog.put(key, value)
+	segID, offset, err := db.datalog.putWithTTL(key, value, expiresAt)
 	if err != nil {
 		return err
 	}
@@ -323,17 +491,34 @@ func (db *DB) Put(key []byte, value []byte) error {
 	return nil
 }
 
+// Put sets the value for the given key. It updates the value for the existing key.
+func (db *DB) Put(key []byte, value []byte) error {
+	return db.putValue(key, value, 0)
+}
+
+// PutWithTTL sets the value for the given key that expires after the ttl duration.
+// It updates the value for the existing key.
+// Expired keys are not returned by Get, Has and ItemIterator, the disk space occupied by expired keys is reclaimed by
+// compaction. Count includes expired keys that are not yet reclaimed.
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return db.putValue(key, value, db.opts.now().Add(ttl).UnixNano())
+}
+
 func (db *DB) del(h uint32, key []byte, writeWAL bool) error {
 	err := db.index.delete(h, func(sl slot) (b bool, e error) {
 		if uint16(len(key)) != sl.keySize {
 			return false, nil
 		}
-		slKey, err := db.datalog.readKey(sl)
+		rec, err := db.datalog.readRecord(sl, false)
 		if err != nil {
 			return true, err
 		}
-		if bytes.Equal(key, slKey) {
-			db.datalog.trackDel(sl)
+		if bytes.Equal(key, rec.key) {
+			db.trackSnapshots(key, sl, true)
+			db.datalog.trackDel(sl, rec)
 			var err error
 			if writeWAL {
 				err = db.datalog.del(key)
@@ -347,10 +532,17 @@ func (db *DB) del(h uint32, key []byte, writeWAL bool) error {
 
 // Delete deletes the given key from the DB.
 func (db *DB) Delete(key []byte) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	defer db.metrics.DeleteLatency.observeSince(time.Now())
 	h := db.hash(key)
 	db.metrics.Dels.Add(1)
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	if db.following {
+		return ErrReadOnly
+	}
 	if err := db.del(h, key, true); err != nil {
 		return err
 	}
@@ -368,22 +560,36 @@ func (db *DB) Close() error {
 	db.closeWg.Wait()
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if err := db.writeMeta(); err != nil {
+	if err := db.closeSnapshots(); err != nil {
 		return err
 	}
+	if err := db.closeValueReaders(); err != nil {
+		return err
+	}
+	if !db.opts.ReadOnly {
+		if err := db.writeMeta(); err != nil {
+			return err
+		}
+	}
 	if err := db.datalog.close(); err != nil {
 		return err
 	}
 	if err := db.index.close(); err != nil {
 		return err
 	}
-	if err := db.lock.Unlock(); err != nil {
-		return err
+	if db.lock != nil {
+		if err := db.lock.Unlock(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (db *DB) sync() error {
+	if db.opts.ReadOnly {
+		return nil
+	}
+	defer db.metrics.SyncLatency.observeSince(time.Now())
 	return db.datalog.sync()
 }
 
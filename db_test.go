@@ -3,10 +3,12 @@ package pogreb
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -141,13 +143,78 @@ func TestFull(t *testing.T) {
 		buf, err = db.GetAppend(key, buf[:0])
 		return buf, err
 	})
+	fullTest(t, func(db *DB, key []byte) ([]byte, error) {
+		values, err := db.GetMany([][]byte{key})
+		if err != nil {
+			return nil, err
+		}
+		return values[0], nil
+	})
+}
+
+func TestGetMany(t *testing.T) {
+	clock := newTestClock()
+	db, err := createTestDB(&Options{now: clock.now})
+	assert.Nil(t, err)
+
+	var keys [][]byte
+	var expected [][]byte
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if i%3 == 0 {
+			// Missing key.
+			keys = append(keys, key)
+			expected = append(expected, nil)
+			continue
+		}
+		value := []byte(fmt.Sprintf("value%d", i))
+		assert.Nil(t, db.Put(key, value))
+		keys = append(keys, key)
+		expected = append(expected, value)
+	}
+	// Duplicate key.
+	keys = append(keys, keys[1])
+	expected = append(expected, expected[1])
+	// Expired key.
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("ttl"), time.Second))
+	keys = append(keys, []byte("ttl"))
+	expected = append(expected, nil)
+	clock.advance(time.Second)
+
+	values, err := db.GetMany(keys)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, values)
+
+	values, err = db.GetMany(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(values))
+
+	bufs := make([][]byte, len(keys)-1)
+	for i := range bufs {
+		bufs[i] = []byte("buf")
+	}
+	values, err = db.GetManyAppend(keys, bufs)
+	assert.Nil(t, err)
+	for i := range keys {
+		if expected[i] == nil {
+			assert.Nil(t, values[i])
+			continue
+		}
+		if i < len(bufs) {
+			assert.Equal(t, append([]byte("buf"), expected[i]...), values[i])
+		} else {
+			assert.Equal(t, expected[i], values[i])
+		}
+	}
+
+	assert.Nil(t, db.Close())
 }
 
 func fullTest(t *testing.T, getFunc func(db *DB, key []byte) ([]byte, error)) {
 	opts := &Options{
 		BackgroundSyncInterval: -1,
 		FileSystem:             testFS,
-		maxSegmentSize:         1024,
+		MaxSegmentSize:         1024,
 	}
 	db, err := createTestDB(opts)
 	assert.Nil(t, err)
@@ -244,6 +311,36 @@ func fullTest(t *testing.T, getFunc func(db *DB, key []byte) ([]byte, error)) {
 	assert.Nil(t, db.Close())
 }
 
+func TestView(t *testing.T) {
+	db, err := createTestDB(&Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}, []byte{1, 2, 3}))
+
+	var value []byte
+	assert.Nil(t, db.View([]byte{1}, func(v []byte) error {
+		value = append(value, v...)
+		return nil
+	}))
+	assert.Equal(t, []byte{1, 2, 3}, value)
+
+	// Missing key.
+	called := false
+	assert.Nil(t, db.View([]byte{2}, func(v []byte) error {
+		called = true
+		assert.Nil(t, v)
+		return nil
+	}))
+	assert.Equal(t, true, called)
+
+	errView := errors.New("view error")
+	assert.Equal(t, errView, db.View([]byte{1}, func(v []byte) error {
+		return errView
+	}))
+	assert.Equal(t, int64(3), db.Metrics().Gets.Value())
+
+	assert.Nil(t, db.Close())
+}
+
 func TestLock(t *testing.T) {
 	opts := &Options{FileSystem: testFS}
 	db, err := createTestDB(opts)
@@ -252,8 +349,165 @@ func TestLock(t *testing.T) {
 	// Opening already opened database returns an error.
 	db2, err2 := Open(testDBName, opts)
 	assert.Nil(t, db2)
-	assert.NotNil(t, err2)
+	assert.Equal(t, true, errors.Is(err2, ErrLocked))
+
+	assert.Nil(t, db.Close())
+}
+
+func TestOpenInvalidOptions(t *testing.T) {
+	for _, opts := range []*Options{
+		{MaxSegmentSize: headerSize},
+		{MaxSegmentSize: 1024, CompactionMinSegmentSize: 2048},
+		{CompactionMinFragmentation: -0.5},
+		{CompactionMinFragmentation: 1.5},
+		{CompactionMinFragmentation: float32(math.NaN())},
+		{CompactionRateLimit: -1},
+		{CompactionBatchSize: -1},
+	} {
+		db, err := createTestDB(opts)
+		assert.Nil(t, db)
+		assert.Equal(t, true, errors.Is(err, ErrInvalidOptions))
+	}
+
+	db, err := createTestDB(&Options{MaxSegmentSize: headerSize + 1, CompactionMinFragmentation: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(headerSize+1), db.opts.CompactionMinSegmentSize)
+	assert.Nil(t, db.Close())
+}
+
+func TestReadOnly(t *testing.T) {
+	opts := &Options{FileSystem: testFS}
+	roOpts := &Options{FileSystem: testFS, ReadOnly: true}
+
+	_, err := Open("nonexistent", roOpts)
+	assert.NotNil(t, err)
+
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
 
+	// The database opened in read-write mode can be opened in read-only mode.
+	// The read-only instance reads the data written before it was opened.
+	ro, err := Open(testDBName, roOpts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{2}, []byte{2}))
+	v, err := ro.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+	v, err = ro.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	assert.Equal(t, uint32(1), ro.Count())
+	assert.Equal(t, ErrReadOnly, ro.Put([]byte{3}, []byte{3}))
+	assert.Nil(t, ro.Close())
+	assert.Nil(t, db.Delete([]byte{2}))
+	assert.Nil(t, db.Close())
+
+	dbSize := func() int64 {
+		files, err := testFS.ReadDir(testDBName)
+		assert.Nil(t, err)
+		var size int64
+		for _, file := range files {
+			fi, err := testFS.Stat(filepath.Join(testDBName, file.Name()))
+			assert.Nil(t, err)
+			size += fi.Size()
+		}
+		return size
+	}
+	size := dbSize()
+
+	// Multiple read-only instances can be opened at the same time.
+	db, err = Open(testDBName, roOpts)
+	assert.Nil(t, err)
+	db2, err := Open(testDBName, roOpts)
+	assert.Nil(t, err)
+
+	// Opening the database opened in read-only mode in read-write mode returns an error.
+	_, err = Open(testDBName, opts)
+	assert.Equal(t, true, errors.Is(err, ErrLocked))
+
+	v, err = db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+	assert.Equal(t, uint32(1), db2.Count())
+
+	assert.Equal(t, ErrReadOnly, db.Put([]byte{2}, []byte{2}))
+	assert.Equal(t, ErrReadOnly, db.PutWithTTL([]byte{2}, []byte{2}, time.Hour))
+	assert.Equal(t, ErrReadOnly, db.Delete([]byte{1}))
+	b := NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{2}, []byte{2}))
+	assert.Equal(t, ErrReadOnly, db.Write(b))
+	_, err = db.Compact()
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Nil(t, db.Sync())
+
+	assert.Nil(t, db.Close())
+	assert.Nil(t, db2.Close())
+	assert.Equal(t, size, dbSize())
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, lockName)))
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, rwLockName)))
+
+	// The database that wasn't closed properly can't be opened in read-only mode.
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+	_, err = Open(testDBName, roOpts)
+	assert.Equal(t, ErrNeedsRecovery, err)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+}
+
+func TestReadOnlyWriterMaintenance(t *testing.T) {
+	opts := &Options{
+		FileSystem:                 testFS,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+
+	ro, err := Open(testDBName, &Options{FileSystem: testFS, ReadOnly: true})
+	assert.Nil(t, err)
+	items := dbItems(t, ro)
+	assert.Equal(t, 100, len(items))
+
+	// The writer compacts and removes the segments the read-only instance has open.
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete([]byte{byte(i)}))
+	}
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, true, cr.CompactedSegments > 0)
+	assert.Equal(t, items, dbItems(t, ro))
+
+	// The writer crashes and recovers.
+	assert.Nil(t, db.Close())
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, items, dbItems(t, ro))
+	assert.Nil(t, ro.Close())
+
+	// Reading a segment truncated by the writer returns an error instead of crashing.
+	ro, err = Open(testDBName, &Options{FileSystem: testFS, ReadOnly: true})
+	assert.Nil(t, err)
+	segments := ro.datalog.segmentsBySequenceID()
+	f, err := testFS.OpenFile(filepath.Join(testDBName, segments[len(segments)-1].name), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Truncate(headerSize))
+	assert.Nil(t, f.Close())
+	it := ro.Items()
+	for {
+		_, _, err = it.Next()
+		if err != nil {
+			break
+		}
+	}
+	assert.Equal(t, false, err == ErrIterationDone)
+	assert.Nil(t, ro.Close())
 	assert.Nil(t, db.Close())
 }
 
@@ -336,6 +590,25 @@ func TestCorruptedIndex(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestCorruptedHeader(t *testing.T) {
+	opts := &Options{FileSystem: testFS}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	f, err := testFS.OpenFile(filepath.Join(testDBName, indexMainName), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	_, err = Open(testDBName, opts)
+	assert.Equal(t, true, errors.Is(err, ErrCorrupted))
+	var cerr *CorruptionError
+	assert.Equal(t, true, errors.As(err, &cerr))
+	assert.Equal(t, &CorruptionError{File: indexMainName, Offset: 0, Reason: "invalid file signature"}, cerr)
+}
+
 func TestFileError(t *testing.T) {
 	db, err := createTestDB(nil)
 	assert.Nil(t, err)
@@ -478,6 +751,46 @@ func BenchmarkGetAppend(b *testing.B) {
 	assert.Nil(b, db.Close())
 }
 
+func BenchmarkView(b *testing.B) {
+	db, err := createTestDB(nil)
+	assert.Nil(b, err)
+	k := []byte{1}
+	if err := db.Put(k, make([]byte, 1024)); err != nil {
+		b.Fail()
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := db.View(k, func(value []byte) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatal()
+		}
+	}
+	assert.Nil(b, db.Close())
+}
+
+func BenchmarkGetMany(b *testing.B) {
+	db, err := createTestDB(nil)
+	assert.Nil(b, err)
+	keys := make([][]byte, 100)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%d", i))
+		if err := db.Put(keys[i], make([]byte, 1024)); err != nil {
+			b.Fail()
+		}
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.GetMany(keys); err != nil {
+			b.Fatal()
+		}
+	}
+	assert.Nil(b, db.Close())
+}
+
 func BenchmarkBucket_UnmarshalBinary(b *testing.B) {
 	testBucket := bucket{
 		slots: [slotsPerBucket]slot{},
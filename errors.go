@@ -1,14 +1,88 @@
 package pogreb
 
 import (
+	"fmt"
+
 	"github.com/akrylysov/pogreb/internal/errors"
 )
 
 var (
-	errKeyTooLarge   = errors.New("key is too large")
-	errValueTooLarge = errors.New("value is too large")
-	errFull          = errors.New("database is full")
-	errCorrupted     = errors.New("database is corrupted")
-	errLocked        = errors.New("database is locked")
-	errBusy          = errors.New("database is busy")
+	// ErrKeyTooLarge is returned when the key is larger than MaxKeyLength.
+	ErrKeyTooLarge = errors.New("key is too large")
+
+	// ErrValueTooLarge is returned when the value is larger than MaxValueLength.
+	ErrValueTooLarge = errors.New("value is too large")
+
+	// ErrFull is returned when the number of keys in the DB reaches MaxKeys.
+	ErrFull = errors.New("database is full")
+
+	// ErrCorrupted is returned when the DB files contain invalid data.
+	// Errors providing the location of the corrupted data are of the *CorruptionError type.
+	ErrCorrupted = errors.New("database is corrupted")
+
+	// ErrLocked is returned when the DB is already opened by another process.
+	ErrLocked = errors.New("database is locked")
+
+	// ErrBusy is returned when another maintenance task is in progress, e.g. compaction.
+	ErrBusy = errors.New("database is busy")
+
+	// ErrBatchTooLarge is returned when the WriteBatch doesn't fit in a segment.
+	ErrBatchTooLarge = errors.New("batch is too large")
+
+	// ErrSnapshotClosed is returned when reading from a closed Snapshot.
+	ErrSnapshotClosed = errors.New("snapshot is closed")
+
+	// ErrInvalidValueSize is returned when the value size passed to PutReader is negative.
+	ErrInvalidValueSize = errors.New("value size is negative")
+
+	// ErrKeyNotFound is returned by GetReader when the key doesn't exist.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrInvalidTTL is returned when the TTL is not positive.
+	ErrInvalidTTL = errors.New("ttl must be positive")
+
+	// ErrInvalidRateLimit is returned when the rate passed to NewRateLimiter is not positive.
+	ErrInvalidRateLimit = errors.New("rate limit must be positive")
+
+	// ErrReadOnly is returned when modifying the DB opened in read-only mode.
+	ErrReadOnly = errors.New("database is read-only")
+
+	// ErrNoMergeOperator is returned when merging values without Options.MergeOperator set.
+	ErrNoMergeOperator = errors.New("merge operator is not set")
+
+	// ErrInvalidMergeOperand is returned by merge operators when the operand or the existing value is invalid.
+	ErrInvalidMergeOperand = errors.New("invalid merge operand")
+
+	// ErrInvalidOptions is returned when opening the DB with invalid Options.
+	ErrInvalidOptions = errors.New("invalid options")
+
+	// ErrClosed is returned by Stats, Verify, Replicate and ChangeFeed.Next when the DB is closed.
+	ErrClosed = errors.New("database is closed")
+
+	// ErrPositionCompacted is returned by change feeds when the segment holding the change position was removed by
+	// compaction.
+	ErrPositionCompacted = errors.New("change position is compacted")
+
+	// ErrUnsupportedVersion is returned when opening the DB files written by a newer version of the package.
+	ErrUnsupportedVersion = errors.New("unsupported file format version")
+
+	// ErrNeedsRecovery is returned when opening the DB in read-only mode if the DB wasn't closed properly.
+	ErrNeedsRecovery = errors.New("database wasn't closed properly and needs recovery")
 )
+
+// CorruptionError describes the location of corrupted data in a DB file.
+// CorruptionError matches ErrCorrupted when compared with errors.Is.
+type CorruptionError struct {
+	File   string // Name of the corrupted file.
+	Offset int64  // Offset of the corrupted data in the file.
+	Reason string // Description of the corruption.
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s: %s at offset %d in %s", ErrCorrupted, e.Reason, e.Offset, e.File)
+}
+
+// Unwrap returns ErrCorrupted.
+func (e *CorruptionError) Unwrap() error {
+	return ErrCorrupted
+}
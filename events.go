@@ -0,0 +1,61 @@
+package pogreb
+
+// EventListener receives notifications about DB maintenance and recovery events.
+//
+// The methods are called synchronously, some of them while the DB lock is held.
+// Implementations must return quickly and must not call DB methods.
+// Embed NoopEventListener to implement only a subset of the methods.
+type EventListener interface {
+	// OnCompactionBegin is called when compaction starts.
+	OnCompactionBegin()
+
+	// OnCompactionEnd is called when compaction finishes, err is the error returned by Compact.
+	OnCompactionEnd(cr CompactionResult, err error)
+
+	// OnSegmentRotated is called when the current segment is full and writes are switched to the next segment.
+	OnSegmentRotated(fullSegment string, nextSegment string)
+
+	// OnRecoveryStart is called when the DB wasn't closed properly and the recovery starts.
+	OnRecoveryStart()
+
+	// OnRecoveryTruncate is called when a corrupted segment is truncated to the last valid record during recovery.
+	OnRecoveryTruncate(segment string, offset int64)
+
+	// OnRecoveryEnd is called when the recovery finishes, err is the error failing the recovery.
+	OnRecoveryEnd(err error)
+
+	// OnBackgroundError is called when the background sync or compaction fails.
+	OnBackgroundError(err error)
+
+	// OnIndexSplit is called when an index bucket is split, numBuckets is the new number of buckets.
+	OnIndexSplit(numBuckets uint32)
+}
+
+// NoopEventListener is an EventListener ignoring all events.
+type NoopEventListener struct{}
+
+// OnCompactionBegin implements EventListener.
+func (NoopEventListener) OnCompactionBegin() {}
+
+// OnCompactionEnd implements EventListener.
+func (NoopEventListener) OnCompactionEnd(CompactionResult, error) {}
+
+// OnSegmentRotated implements EventListener.
+func (NoopEventListener) OnSegmentRotated(string, string) {}
+
+// OnRecoveryStart implements EventListener.
+func (NoopEventListener) OnRecoveryStart() {}
+
+// OnRecoveryTruncate implements EventListener.
+func (NoopEventListener) OnRecoveryTruncate(string, int64) {}
+
+// OnRecoveryEnd implements EventListener.
+func (NoopEventListener) OnRecoveryEnd(error) {}
+
+// OnBackgroundError implements EventListener.
+func (NoopEventListener) OnBackgroundError(error) {}
+
+// OnIndexSplit implements EventListener.
+func (NoopEventListener) OnIndexSplit(uint32) {}
+
+var _ EventListener = NoopEventListener{}
@@ -0,0 +1,142 @@
+package pogreb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+// testEventListener records the received events.
+type testEventListener struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *testEventListener) record(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf(format, args...))
+}
+
+// reset returns the recorded events and clears the list.
+func (l *testEventListener) reset() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events
+	l.events = nil
+	return events
+}
+
+func (l *testEventListener) OnCompactionBegin() {
+	l.record("compaction begin")
+}
+
+func (l *testEventListener) OnCompactionEnd(cr CompactionResult, err error) {
+	l.record("compaction end %+v %v", cr, err)
+}
+
+func (l *testEventListener) OnSegmentRotated(fullSegment string, nextSegment string) {
+	l.record("segment rotated %s %s", fullSegment, nextSegment)
+}
+
+func (l *testEventListener) OnRecoveryStart() {
+	l.record("recovery start")
+}
+
+func (l *testEventListener) OnRecoveryTruncate(segment string, offset int64) {
+	l.record("recovery truncate %s %d", segment, offset)
+}
+
+func (l *testEventListener) OnRecoveryEnd(err error) {
+	l.record("recovery end %v", err)
+}
+
+func (l *testEventListener) OnBackgroundError(err error) {
+	l.record("background error %v", err)
+}
+
+func (l *testEventListener) OnIndexSplit(numBuckets uint32) {
+	l.record("index split %d", numBuckets)
+}
+
+// errSyncFile is a file failing to sync.
+type errSyncFile struct {
+	fs.File
+}
+
+func (f errSyncFile) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestEventListener(t *testing.T) {
+	listener := &testEventListener{}
+	opts := &Options{
+		EventListener:              listener,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	// The first segment fits 42 items (12 bytes per item, 1 byte key, 1 byte value).
+	for i := 0; i < 43; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	assert.Equal(t, []string{
+		"index split 2",
+		"segment rotated 00000-1.psg 00001-2.psg",
+	}, listener.reset())
+
+	for i := 0; i < 42; i++ {
+		assert.Nil(t, db.Delete([]byte{byte(i)}))
+	}
+	_, err = db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"compaction begin",
+		"segment rotated 00001-2.psg 00000-3.psg",
+		"compaction end {CompactedSegments:2 ReclaimedRecords:84 ReclaimedBytes:966} <nil>",
+	}, listener.reset())
+	assert.Nil(t, db.Close())
+
+	// Corrupt the last segment and recover.
+	f, err := openFile(testFS, testDBName+"/00000-3.psg", openFileFlags{})
+	assert.Nil(t, err)
+	_, err = f.append([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	assert.Nil(t, touchFile(testFS, testDBName+"/"+lockName))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"recovery start",
+		"recovery truncate 00000-3.psg 524",
+		"recovery end <nil>",
+	}, listener.reset())
+	assert.Nil(t, db.Close())
+
+	// Background errors.
+	opts.BackgroundSyncInterval = time.Millisecond
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	db.mu.Lock()
+	segFile := db.datalog.curSeg.File
+	db.datalog.curSeg.File = errSyncFile{segFile}
+	db.mu.Unlock()
+	assert.CompleteWithin(t, time.Minute, func() bool {
+		listener.mu.Lock()
+		defer listener.mu.Unlock()
+		return len(listener.events) > 0
+	})
+	db.mu.Lock()
+	db.datalog.curSeg.File = segFile
+	db.mu.Unlock()
+	assert.Nil(t, db.Close())
+	assert.Equal(t, "background error sync failed", listener.reset()[0])
+}
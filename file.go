@@ -5,13 +5,15 @@ import (
 	"os"
 
 	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/errors"
 )
 
 // file is a database file.
 // When stored in a file system, the file starts with a header.
 type file struct {
 	fs.File
-	size int64
+	size    int64
+	version uint32 // Format version from the file header.
 }
 
 type openFileFlags struct {
@@ -53,6 +55,10 @@ func openFile(fsyst fs.FileSystem, name string, flags openFileFlags) (*file, err
 		}
 	} else {
 		if err := f.readHeader(); err != nil {
+			var cerr *CorruptionError
+			if errors.As(err, &cerr) {
+				cerr.File = name
+			}
 			return nil, err
 		}
 	}
@@ -72,6 +78,7 @@ func (f *file) writeHeader() error {
 	if _, err = f.append(data); err != nil {
 		return err
 	}
+	f.version = h.formatVersion
 	return nil
 }
 
@@ -81,7 +88,28 @@ func (f *file) readHeader() error {
 	if _, err := io.ReadFull(f, buf); err != nil {
 		return err
 	}
-	return h.UnmarshalBinary(buf)
+	if err := h.UnmarshalBinary(buf); err != nil {
+		return err
+	}
+	f.version = h.formatVersion
+	return nil
+}
+
+// upgradeHeader rewrites the header of the file written by an older version with the current format version.
+// It must be called before writing data older versions can't read.
+func (f *file) upgradeHeader() error {
+	if f.version >= formatVersion {
+		return nil
+	}
+	data, err := newHeader().MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	f.version = formatVersion
+	return nil
 }
 
 func (f *file) empty() bool {
@@ -105,3 +133,16 @@ func (f *file) append(data []byte) (int64, error) {
 	f.size += int64(len(data))
 	return off, nil
 }
+
+// offsetWriter writes to the file sequentially starting at the given offset.
+// The file size is not updated by offsetWriter.
+type offsetWriter struct {
+	f   *file
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
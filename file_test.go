@@ -3,9 +3,11 @@ package pogreb
 import (
 	"errors"
 	"os"
+	"testing"
 	"time"
 
 	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/assert"
 )
 
 type errfs struct{}
@@ -120,3 +122,33 @@ func (m *errfile) Munmap() error {
 
 // Compile time interface assertion.
 var _ fs.File = &errfile{}
+
+func TestFileFormatVersion(t *testing.T) {
+	const name = testDBName + ".version"
+	write := func(version uint32) {
+		h := newHeader()
+		h.formatVersion = version
+		data, err := h.MarshalBinary()
+		assert.Nil(t, err)
+		f, err := testFS.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0640)
+		assert.Nil(t, err)
+		_, err = f.Write(data)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+	defer func() {
+		_ = testFS.Remove(name)
+	}()
+
+	// Files written by older versions are readable.
+	for version := uint32(1); version <= formatVersion; version++ {
+		write(version)
+		f, err := openFile(testFS, name, openFileFlags{readOnly: true})
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+	}
+
+	write(formatVersion + 1)
+	_, err := openFile(testFS, name, openFileFlags{readOnly: true})
+	assert.Equal(t, true, errors.Is(err, ErrUnsupportedVersion))
+}
@@ -11,6 +11,7 @@ import (
 
 var (
 	errAppendModeNotSupported = errors.New("append mode is not supported")
+	errLinkNotSupported       = errors.New("hard links are not supported")
 )
 
 // File is the interface compatible with os.File.
@@ -42,6 +43,36 @@ type LockFile interface {
 	Unlock() error
 }
 
+// SharedLocker is implemented by file systems supporting shared lock files.
+// A shared lock can be held by multiple lock files at the same time, but not together with a lock created by
+// FileSystem.CreateLockFile.
+type SharedLocker interface {
+	// CreateSharedLockFile creates a lock file and acquires a shared lock.
+	// The returned bool reports whether the lock file was left behind by a process that didn't unlock it.
+	// The lock file is removed by the last unlocked shared lock.
+	CreateSharedLockFile(name string, perm os.FileMode) (LockFile, bool, error)
+}
+
+// Linker is implemented by file systems supporting hard links.
+type Linker interface {
+	// Link creates newname as a hard link to the oldname file.
+	Link(oldname, newname string) error
+}
+
+// DirSyncer is implemented by file systems supporting syncing directories.
+type DirSyncer interface {
+	// SyncDir commits the directory entries, making the files created, renamed or removed in the directory durable.
+	SyncDir(name string) error
+}
+
+// Mmapper is implemented by file systems memory-mapping files.
+type Mmapper interface {
+	// WithoutMmap returns the file system reading files with ReadAt instead of memory-mapping them.
+	// Reading a memory-mapped file truncated by another process crashes the program, reading it with ReadAt returns
+	// an error.
+	WithoutMmap() FileSystem
+}
+
 // FileSystem represents a file system.
 type FileSystem interface {
 	// OpenFile opens the file with specified flag.
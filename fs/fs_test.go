@@ -58,6 +58,78 @@ func testLockFileAcquireExisting(t *testing.T, fs FileSystem) {
 	assert.NotNil(t, err)
 }
 
+func testSharedLockFile(t *testing.T, fs FileSystem) {
+	_ = fs.Remove(lockTestPath)
+	sl := fs.(SharedLocker)
+	lock, acquiredExisting, err := sl.CreateSharedLockFile(lockTestPath, lockTestMode)
+	if lock == nil || acquiredExisting || err != nil {
+		t.Fatal(lock, err, acquiredExisting)
+	}
+	lock2, acquiredExisting2, err2 := sl.CreateSharedLockFile(lockTestPath, lockTestMode)
+	if lock2 == nil || acquiredExisting2 || err2 != nil {
+		t.Fatal(lock2, acquiredExisting2, err2)
+	}
+
+	// Exclusive lock can't be acquired while shared locks are held.
+	lock3, acquiredExisting3, err3 := fs.CreateLockFile(lockTestPath, lockTestMode)
+	if lock3 != nil || acquiredExisting3 || err3 != os.ErrExist {
+		t.Fatal(lock3, acquiredExisting3, err3)
+	}
+
+	// The lock file is removed by the last lock.
+	assert.Nil(t, lock.Unlock())
+	_, err = fs.Stat(lockTestPath)
+	assert.Nil(t, err)
+	assert.Nil(t, lock2.Unlock())
+	_, err = fs.Stat(lockTestPath)
+	assert.NotNil(t, err)
+
+	// Shared lock can't be acquired while the exclusive lock is held.
+	lock, _, err = fs.CreateLockFile(lockTestPath, lockTestMode)
+	assert.Nil(t, err)
+	lock2, acquiredExisting2, err2 = sl.CreateSharedLockFile(lockTestPath, lockTestMode)
+	if lock2 != nil || acquiredExisting2 || err2 != os.ErrExist {
+		t.Fatal(lock2, acquiredExisting2, err2)
+	}
+	assert.Nil(t, lock.Unlock())
+
+	// Acquire existing lock file.
+	assert.Nil(t, touchFile(fs, lockTestPath))
+	lock, acquiredExisting, err = sl.CreateSharedLockFile(lockTestPath, lockTestMode)
+	if lock == nil || !acquiredExisting || err != nil {
+		t.Fatal(lock, err, acquiredExisting)
+	}
+	assert.Nil(t, lock.Unlock())
+	_, err = fs.Stat(lockTestPath)
+	assert.NotNil(t, err)
+}
+
+func testLink(t *testing.T, fsys FileSystem) {
+	f, err := fsys.OpenFile("test", os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(0666))
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	assert.Nil(t, fsys.(Linker).Link("test", "test.link"))
+	fi, err := fsys.Stat("test")
+	assert.Nil(t, err)
+	fi2, err := fsys.Stat("test.link")
+	assert.Nil(t, err)
+	assert.Equal(t, true, os.SameFile(fi, fi2))
+
+	// The link remains after removing the original file.
+	assert.Nil(t, fsys.Remove("test"))
+	f, err = fsys.OpenFile("test.link", os.O_RDONLY, os.FileMode(0666))
+	assert.Nil(t, err)
+	data, err := io.ReadAll(f)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, data)
+	assert.Nil(t, f.Close())
+
+	assert.NotNil(t, fsys.(Linker).Link("test", "test.link2"))
+}
+
 func testFS(t *testing.T, fsys FileSystem) {
 	f, err := fsys.OpenFile("test", os.O_CREATE|os.O_RDWR|os.O_TRUNC, os.FileMode(0666))
 	assert.Nil(t, err)
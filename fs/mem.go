@@ -13,7 +13,12 @@ type memFS struct {
 
 // Mem is a file system backed by memory.
 // It should be used for testing only.
-var Mem FileSystem = &memFS{files: map[string]*memFile{}}
+var Mem FileSystem = NewMem()
+
+// NewMem returns a new empty file system backed by memory.
+func NewMem() FileSystem {
+	return &memFS{files: map[string]*memFile{}}
+}
 
 func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
 	if flag&os.O_APPEND != 0 {
@@ -28,6 +33,7 @@ func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error)
 			return nil, os.ErrNotExist
 		}
 		f = &memFile{
+			fs:   fs,
 			name: name,
 			perm: perm, // Perm is saved to return it in Mode, but don't do anything else with it yet.
 			refs: 1,
@@ -55,6 +61,21 @@ func (fs *memFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool,
 	return fs.files[name], exists, nil
 }
 
+func (fs *memFS) CreateSharedLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	f, exists := fs.files[name]
+	if f != nil && f.refs > f.sharedLocks {
+		// The file is locked exclusively.
+		return nil, false, os.ErrExist
+	}
+	acquiredExisting := exists && f.refs == 0
+	if _, err := fs.OpenFile(name, os.O_CREATE, perm); err != nil {
+		return nil, false, err
+	}
+	f = fs.files[name]
+	f.sharedLocks++
+	return &memSharedLockFile{f}, acquiredExisting, nil
+}
+
 func (fs *memFS) Stat(name string) (os.FileInfo, error) {
 	if f, ok := fs.files[name]; ok {
 		return f, nil
@@ -98,11 +119,13 @@ func (fs *memFS) MkdirAll(path string, perm os.FileMode) error {
 }
 
 type memFile struct {
-	name string
-	perm os.FileMode
-	buf  []byte
-	size int64
-	refs int
+	fs          *memFS
+	name        string
+	perm        os.FileMode
+	buf         []byte
+	size        int64
+	refs        int
+	sharedLocks int // Number of shared locks held on the file.
 }
 
 func (f *memFile) Close() error {
@@ -117,7 +140,23 @@ func (f *memFile) Unlock() error {
 	if err := f.Close(); err != nil {
 		return err
 	}
-	return Mem.Remove(f.name)
+	return f.fs.Remove(f.name)
+}
+
+type memSharedLockFile struct {
+	*memFile
+}
+
+func (f *memSharedLockFile) Unlock() error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	f.sharedLocks--
+	if f.refs > 0 {
+		// The lock is held by other lock files.
+		return nil
+	}
+	return f.fs.Remove(f.name)
 }
 
 func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
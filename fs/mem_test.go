@@ -1,7 +1,10 @@
 package fs
 
 import (
+	"errors"
 	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
 )
 
 func TestMemFS(t *testing.T) {
@@ -15,3 +18,20 @@ func TestMemLockFile(t *testing.T) {
 func TestMemLockAcquireExisting(t *testing.T) {
 	testLockFileAcquireExisting(t, Mem)
 }
+
+func TestMemSharedLockFile(t *testing.T) {
+	testSharedLockFile(t, Mem)
+}
+
+func TestMemLink(t *testing.T) {
+	_, ok := Mem.(Linker)
+	assert.Equal(t, false, ok)
+	err := Sub(Mem, "sub").(Linker).Link("test", "test.link")
+	assert.Equal(t, true, errors.Is(err, errLinkNotSupported))
+}
+
+func TestMemSyncDir(t *testing.T) {
+	_, ok := Mem.(DirSyncer)
+	assert.Equal(t, false, ok)
+	assert.Nil(t, Sub(Mem, "sub").(DirSyncer).SyncDir("."))
+}
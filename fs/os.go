@@ -21,6 +21,10 @@ func (fs *osFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool, e
 	return createLockFile(name, perm)
 }
 
+func (fs *osFS) CreateSharedLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	return createSharedLockFile(name, perm)
+}
+
 func (fs *osFS) Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
@@ -41,6 +45,14 @@ func (fs *osFS) MkdirAll(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
 
+func (fs *osFS) Link(oldname, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+func (fs *osFS) SyncDir(name string) error {
+	return syncDir(name)
+}
+
 type osFile struct {
 	*os.File
 }
@@ -44,6 +44,11 @@ func (fs *osMMapFS) OpenFile(name string, flag int, perm os.FileMode) (File, err
 	return mf, nil
 }
 
+// WithoutMmap returns OS.
+func (fs *osMMapFS) WithoutMmap() FileSystem {
+	return OS
+}
+
 type osMMapFile struct {
 	*os.File
 	data     []byte
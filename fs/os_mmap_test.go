@@ -1,9 +1,22 @@
 package fs
 
 import (
+	"os"
 	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
 )
 
 func TestOSMMapFS(t *testing.T) {
 	testFS(t, Sub(OSMMap, t.TempDir()))
 }
+
+func TestOSMMapFSWithoutMmap(t *testing.T) {
+	fsys := Sub(OSMMap, t.TempDir()).(Mmapper).WithoutMmap()
+	f, err := fsys.OpenFile("test", os.O_CREATE|os.O_RDWR, 0640)
+	assert.Nil(t, err)
+	_, ok := f.(*osFile)
+	assert.Equal(t, true, ok)
+	assert.Nil(t, f.Close())
+	testFS(t, Sub(OSMMap, t.TempDir()).(Mmapper).WithoutMmap())
+}
@@ -20,6 +20,16 @@ func createLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
 	return &osLockFile{f, name}, acquiredExisting, nil
 }
 
+// createSharedLockFile creates an exclusive lock file, Plan 9 doesn't support shared locks.
+func createSharedLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	return createLockFile(name, perm)
+}
+
+// syncDir does nothing, Plan 9 doesn't support syncing directories.
+func syncDir(name string) error {
+	return nil
+}
+
 // Return a default FileSystem for this platform.
 func DefaultFileSystem() FileSystem {
 	return OS
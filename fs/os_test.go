@@ -2,12 +2,25 @@ package fs
 
 import (
 	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
 )
 
 func TestOSFS(t *testing.T) {
 	testFS(t, Sub(OS, t.TempDir()))
 }
 
+func TestOSLink(t *testing.T) {
+	testLink(t, Sub(OS, t.TempDir()))
+}
+
+func TestOSSyncDir(t *testing.T) {
+	fsys := Sub(OS, t.TempDir())
+	assert.Nil(t, fsys.MkdirAll("dir", 0755))
+	assert.Nil(t, fsys.(DirSyncer).SyncDir("dir"))
+	assert.NotNil(t, fsys.(DirSyncer).SyncDir("nonexistent"))
+}
+
 func TestOSLockFile(t *testing.T) {
 	testLockFile(t, Sub(OS, t.TempDir()))
 }
@@ -15,3 +28,7 @@ func TestOSLockFile(t *testing.T) {
 func TestOSLockAcquireExisting(t *testing.T) {
 	testLockFileAcquireExisting(t, Sub(OS, t.TempDir()))
 }
+
+func TestOSSharedLockFile(t *testing.T) {
+	testSharedLockFile(t, Sub(OS, t.TempDir()))
+}
@@ -9,19 +9,116 @@ import (
 )
 
 func createLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
-	acquiredExisting := false
-	if _, err := os.Stat(name); err == nil {
-		acquiredExisting = true
+	for {
+		acquiredExisting := false
+		if _, err := os.Stat(name); err == nil {
+			acquiredExisting = true
+		}
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			_ = f.Close()
+			if err == syscall.EWOULDBLOCK {
+				err = os.ErrExist
+			}
+			return nil, false, err
+		}
+		// The previous holder of the lock may have removed the lock file before the lock was acquired.
+		// Start over when the locked file is not the one at the lock file path.
+		if ok, err := isLockFile(f, name); !ok {
+			_ = f.Close()
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		return &osLockFile{f, name}, acquiredExisting, nil
 	}
-	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm)
+}
+
+// isLockFile returns true if the locked file is the file at the lock file path.
+func isLockFile(f *os.File, name string) (bool, error) {
+	fi, err := f.Stat()
 	if err != nil {
-		return nil, false, err
+		return false, err
+	}
+	pathFi, err := os.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return os.SameFile(fi, pathFi), nil
+}
+
+func createSharedLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	for {
+		acquiredExisting := false
+		if _, err := os.Stat(name); err == nil {
+			acquiredExisting = true
+		}
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, perm)
+		if err != nil {
+			return nil, false, err
+		}
+		fd := int(f.Fd())
+		if acquiredExisting {
+			// The existing lock file was left behind only if no other process holds the lock.
+			if err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+				if err != syscall.EWOULDBLOCK {
+					_ = f.Close()
+					return nil, false, err
+				}
+				acquiredExisting = false
+			}
+		}
+		if err := syscall.Flock(fd, syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+			_ = f.Close()
+			if err == syscall.EWOULDBLOCK {
+				err = os.ErrExist
+			}
+			return nil, false, err
+		}
+		// The last holder of the lock may have removed the lock file before the lock was acquired.
+		// Start over when the locked file is not the one at the lock file path.
+		if ok, err := isLockFile(f, name); !ok {
+			_ = f.Close()
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		return &osSharedLockFile{f, name}, acquiredExisting, nil
 	}
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
-		if err == syscall.EWOULDBLOCK {
-			err = os.ErrExist
+}
+
+type osSharedLockFile struct {
+	*os.File
+	path string
+}
+
+func (f *osSharedLockFile) Unlock() error {
+	// Remove the lock file only if no other process holds the lock.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+		if err := os.Remove(f.path); err != nil {
+			_ = f.Close()
+			return err
 		}
-		return nil, false, err
 	}
-	return &osLockFile{f, name}, acquiredExisting, nil
+	return f.Close()
+}
+
+func syncDir(name string) error {
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
 }
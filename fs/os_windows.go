@@ -10,22 +10,26 @@ import (
 )
 
 var (
-	modkernel32    = syscall.NewLazyDLL("kernel32.dll")
-	procLockFileEx = modkernel32.NewProc("LockFileEx")
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
 )
 
 const (
 	errorLockViolation = 0x21
+
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
 )
 
-func lockfile(f *os.File) error {
+func lockfile(f *os.File, flags uintptr) error {
 	var ol syscall.Overlapped
 
 	r1, _, err := syscall.Syscall6(
 		procLockFileEx.Addr(),
 		6,
 		uintptr(f.Fd()), // handle
-		uintptr(0x0003),
+		flags,
 		uintptr(0), // reserved
 		uintptr(1), // locklow
 		uintptr(0), // lockhigh
@@ -37,6 +41,25 @@ func lockfile(f *os.File) error {
 	return nil
 }
 
+func unlockfile(f *os.File) error {
+	var ol syscall.Overlapped
+
+	r1, _, err := syscall.Syscall6(
+		procUnlockFileEx.Addr(),
+		5,
+		uintptr(f.Fd()), // handle
+		uintptr(0),      // reserved
+		uintptr(1),      // locklow
+		uintptr(0),      // lockhigh
+		uintptr(unsafe.Pointer(&ol)),
+		0,
+	)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
 func createLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
 	acquiredExisting := false
 	if _, err := os.Stat(name); err == nil {
@@ -53,9 +76,66 @@ func createLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
 		return nil, false, os.ErrExist
 	}
 	f := os.NewFile(uintptr(fd), name)
-	if err := lockfile(f); err != nil {
+	if err := lockfile(f, lockfileExclusiveLock|lockfileFailImmediately); err != nil {
 		f.Close()
 		return nil, false, err
 	}
 	return &osLockFile{f, name}, acquiredExisting, nil
 }
+
+func createSharedLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	acquiredExisting := false
+	if _, err := os.Stat(name); err == nil {
+		acquiredExisting = true
+	}
+	fd, err := syscall.CreateFile(&(syscall.StringToUTF16(name)[0]),
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0)
+	if err != nil {
+		return nil, false, os.ErrExist
+	}
+	f := os.NewFile(uintptr(fd), name)
+	if acquiredExisting {
+		// The existing lock file was left behind only if no other process holds the lock.
+		if err := lockfile(f, lockfileExclusiveLock|lockfileFailImmediately); err != nil {
+			acquiredExisting = false
+		} else if err := unlockfile(f); err != nil {
+			f.Close()
+			return nil, false, err
+		}
+	}
+	if err := lockfile(f, lockfileFailImmediately); err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return &osSharedLockFile{f, name}, acquiredExisting, nil
+}
+
+type osSharedLockFile struct {
+	*os.File
+	path string
+}
+
+func (f *osSharedLockFile) Unlock() error {
+	if err := unlockfile(f.File); err != nil {
+		f.Close()
+		return err
+	}
+	// Remove the lock file only if no other process holds the lock.
+	if err := lockfile(f.File, lockfileExclusiveLock|lockfileFailImmediately); err == nil {
+		if err := os.Remove(f.path); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// syncDir does nothing, Windows doesn't support syncing directories.
+func syncDir(name string) error {
+	return nil
+}
@@ -49,9 +49,52 @@ func (fs *subFS) CreateLockFile(name string, perm os.FileMode) (LockFile, bool,
 	return fs.fsys.CreateLockFile(subName, perm)
 }
 
+// CreateSharedLockFile creates a shared lock file.
+// It falls back to CreateLockFile when the parent file system doesn't support shared locks.
+func (fs *subFS) CreateSharedLockFile(name string, perm os.FileMode) (LockFile, bool, error) {
+	subName := filepath.Join(fs.root, name)
+	if sl, ok := fs.fsys.(SharedLocker); ok {
+		return sl.CreateSharedLockFile(subName, perm)
+	}
+	return fs.fsys.CreateLockFile(subName, perm)
+}
+
 func (fs *subFS) MkdirAll(path string, perm os.FileMode) error {
 	subPath := filepath.Join(fs.root, path)
 	return fs.fsys.MkdirAll(subPath, perm)
 }
 
+// Link creates a hard link.
+// It returns an error when the parent file system doesn't support hard links.
+func (fs *subFS) Link(oldname, newname string) error {
+	subOldname := filepath.Join(fs.root, oldname)
+	subNewname := filepath.Join(fs.root, newname)
+	if l, ok := fs.fsys.(Linker); ok {
+		return l.Link(subOldname, subNewname)
+	}
+	return &os.LinkError{Op: "link", Old: subOldname, New: subNewname, Err: errLinkNotSupported}
+}
+
+// SyncDir syncs the directory.
+// It does nothing when the parent file system doesn't support syncing directories.
+func (fs *subFS) SyncDir(name string) error {
+	if ds, ok := fs.fsys.(DirSyncer); ok {
+		return ds.SyncDir(filepath.Join(fs.root, name))
+	}
+	return nil
+}
+
+// WithoutMmap returns the file system rooted at the same directory of the parent file system without memory-mapping.
+// It returns the file system itself when the parent file system doesn't memory-map files.
+func (fs *subFS) WithoutMmap() FileSystem {
+	if m, ok := fs.fsys.(Mmapper); ok {
+		return Sub(m.WithoutMmap(), fs.root)
+	}
+	return fs
+}
+
 var _ FileSystem = &subFS{}
+var _ SharedLocker = &subFS{}
+var _ Linker = &subFS{}
+var _ DirSyncer = &subFS{}
+var _ Mmapper = &subFS{}
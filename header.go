@@ -3,10 +3,15 @@ package pogreb
 import (
 	"bytes"
 	"encoding/binary"
+
+	"github.com/akrylysov/pogreb/internal/errors"
 )
 
 const (
-	formatVersion = 2 // File format version.
+	// File format version.
+	// Version 3 adds extended segment records, files written by version 3 can't be read by older versions.
+	// Segments written by older versions are upgraded before the first extended record is appended.
+	formatVersion = 3
 	headerSize    = 512
 )
 
@@ -35,9 +40,12 @@ func (h header) MarshalBinary() ([]byte, error) {
 
 func (h *header) UnmarshalBinary(data []byte) error {
 	if !bytes.Equal(data[:8], signature[:]) {
-		return errCorrupted
+		return &CorruptionError{Reason: "invalid file signature"}
 	}
 	copy(h.signature[:], data[:8])
 	h.formatVersion = binary.LittleEndian.Uint32(data[8:12])
+	if h.formatVersion > formatVersion {
+		return errors.Wrapf(ErrUnsupportedVersion, "file format version %d", h.formatVersion)
+	}
 	return nil
 }
@@ -1,6 +1,8 @@
 package pogreb
 
 import (
+	"sort"
+
 	"github.com/akrylysov/pogreb/internal/errors"
 )
 
@@ -38,11 +40,12 @@ type indexMeta struct {
 type matchKeyFunc func(slot) (bool, error)
 
 func openIndex(opts *Options) (*index, error) {
-	main, err := openFile(opts.FileSystem, indexMainName, openFileFlags{})
+	flags := openFileFlags{readOnly: opts.ReadOnly}
+	main, err := openFile(opts.FileSystem, indexMainName, flags)
 	if err != nil {
 		return nil, errors.Wrap(err, "opening main index")
 	}
-	overflow, err := openFile(opts.FileSystem, indexOverflowName, openFileFlags{})
+	overflow, err := openFile(opts.FileSystem, indexOverflowName, flags)
 	if err != nil {
 		_ = main.Close()
 		return nil, errors.Wrap(err, "opening overflow index")
@@ -68,15 +71,18 @@ func openIndex(opts *Options) (*index, error) {
 	return idx, nil
 }
 
-func (idx *index) writeMeta() error {
-	m := indexMeta{
+func (idx *index) meta() indexMeta {
+	return indexMeta{
 		Level:               idx.level,
 		NumKeys:             idx.numKeys,
 		NumBuckets:          idx.numBuckets,
 		SplitBucketIndex:    idx.splitBucketIdx,
 		FreeOverflowBuckets: idx.freeBucketOffs,
 	}
-	return writeGobFile(idx.opts.FileSystem, indexMetaName, m)
+}
+
+func (idx *index) writeMeta() error {
+	return writeGobFile(idx.opts.FileSystem, indexMetaName, idx.meta())
 }
 
 func (idx *index) readMeta() error {
@@ -93,9 +99,14 @@ func (idx *index) readMeta() error {
 }
 
 func (idx *index) bucketIndex(hash uint32) uint32 {
-	bidx := hash & ((1 << idx.level) - 1)
-	if bidx < idx.splitBucketIdx {
-		return hash & ((1 << (idx.level + 1)) - 1)
+	return bucketIndex(hash, idx.level, idx.splitBucketIdx)
+}
+
+// bucketIndex returns the bucket index for the hash in an index with the given level and split bucket index.
+func bucketIndex(hash uint32, level uint8, splitBucketIdx uint32) uint32 {
+	bidx := hash & ((1 << level) - 1)
+	if bidx < splitBucketIdx {
+		return hash & ((1 << (level + 1)) - 1)
 	}
 	return bidx
 }
@@ -158,6 +169,71 @@ func (idx *index) get(hash uint32, matchKey matchKeyFunc) error {
 	}
 }
 
+// getMany looks up multiple hashes at once.
+// Hashes are grouped by bucket index, each bucket is read once for all hashes landing in it.
+// matchKey is called with the position of the hash being matched.
+func (idx *index) getMany(hashes []uint32, matchKey func(int, slot) (bool, error)) error {
+	bidxs := make([]uint32, len(hashes))
+	order := make([]int, len(hashes))
+	for i, h := range hashes {
+		bidxs[i] = idx.bucketIndex(h)
+		order[i] = i
+	}
+	// Visiting buckets in the file order.
+	sort.Slice(order, func(a, b int) bool {
+		return bidxs[order[a]] < bidxs[order[b]]
+	})
+	for start := 0; start < len(order); {
+		end := start + 1
+		for end < len(order) && bidxs[order[end]] == bidxs[order[start]] {
+			end++
+		}
+		if err := idx.getGroup(bidxs[order[start]], hashes, order[start:end], matchKey); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// getGroup looks up the hashes at the given positions, all of them landing in the same bucket.
+// Matched positions are removed from the group.
+func (idx *index) getGroup(bucketIdx uint32, hashes []uint32, group []int, matchKey func(int, slot) (bool, error)) error {
+	it := idx.newBucketIterator(bucketIdx)
+	for len(group) > 0 {
+		b, err := it.next()
+		if err == ErrIterationDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < slotsPerBucket && len(group) > 0; i++ {
+			sl := b.slots[i]
+			// No more slots in the bucket.
+			if sl.offset == 0 {
+				break
+			}
+			for j := 0; j < len(group); j++ {
+				pos := group[j]
+				if hashes[pos] != sl.hash {
+					continue
+				}
+				match, err := matchKey(pos, sl)
+				if err != nil {
+					return err
+				}
+				if match {
+					group[j] = group[len(group)-1]
+					group = group[:len(group)-1]
+					j--
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (idx *index) findInsertionBucket(newSlot slot, matchKey matchKeyFunc) (*slotWriter, bool, error) {
 	sw := &slotWriter{}
 	it := idx.newBucketIterator(idx.bucketIndex(newSlot.hash))
@@ -202,12 +278,17 @@ func (idx *index) findInsertionBucket(newSlot slot, matchKey matchKeyFunc) (*slo
 
 func (idx *index) put(newSlot slot, matchKey matchKeyFunc) error {
 	if idx.numKeys == MaxKeys {
-		return errFull
+		return ErrFull
 	}
 	sw, overwritingExisting, err := idx.findInsertionBucket(newSlot, matchKey)
 	if err != nil {
 		return err
 	}
+	return idx.insert(sw, newSlot, overwritingExisting)
+}
+
+// insert writes the new slot using the slot writer returned by findInsertionBucket.
+func (idx *index) insert(sw *slotWriter, newSlot slot, overwritingExisting bool) error {
 	if err := sw.insert(newSlot, idx); err != nil {
 		return err
 	}
@@ -342,12 +423,15 @@ func (idx *index) split() error {
 	}
 
 	idx.numBuckets++
+	idx.opts.EventListener.OnIndexSplit(idx.numBuckets)
 	return nil
 }
 
 func (idx *index) close() error {
-	if err := idx.writeMeta(); err != nil {
-		return err
+	if !idx.opts.ReadOnly {
+		if err := idx.writeMeta(); err != nil {
+			return err
+		}
 	}
 	if err := idx.main.Close(); err != nil {
 		return err
@@ -40,3 +40,13 @@ func Wrapf(cause error, format string, a ...interface{}) error {
 		msg:   fmt.Sprintf(format, a...),
 	}
 }
+
+// Is reports whether any error in err's chain matches target.
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, and if so, sets target to that error value.
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
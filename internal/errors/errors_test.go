@@ -31,6 +31,7 @@ func TestIs(t *testing.T) {
 	assert.Equal(t, true, errors.Is(w11, err1))
 	assert.Equal(t, true, errors.Is(w12, err1))
 	assert.Equal(t, true, errors.Is(w12, w11))
+	assert.Equal(t, true, Is(w12, err1))
 
 	assert.Equal(t, false, errors.Is(err1, err2))
 	assert.Equal(t, false, errors.Is(w11, err2))
@@ -38,3 +39,16 @@ func TestIs(t *testing.T) {
 	assert.Equal(t, false, errors.Is(w21, err1))
 	assert.Equal(t, false, errors.Is(w21, w11))
 }
+
+type testError struct{}
+
+func (testError) Error() string {
+	return "test"
+}
+
+func TestAs(t *testing.T) {
+	err := Wrap(Wrap(testError{}, "wrapped 1"), "wrapped 2")
+	var terr testError
+	assert.Equal(t, true, As(err, &terr))
+	assert.Equal(t, false, As(New("err"), &terr))
+}
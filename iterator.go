@@ -16,6 +16,7 @@ type item struct {
 // ItemIterator is an iterator over DB key-value pairs. It iterates the items in an unspecified order.
 type ItemIterator struct {
 	db            *DB
+	snap          *Snapshot // Snapshot to iterate over, nil when iterating over the DB.
 	nextBucketIdx uint32
 	queue         []item
 	mu            sync.Mutex
@@ -23,6 +24,7 @@ type ItemIterator struct {
 
 // fetchItems adds items to the iterator queue from a bucket located at nextBucketIdx.
 func (it *ItemIterator) fetchItems(nextBucketIdx uint32) error {
+	now := it.db.now()
 	bit := it.db.index.newBucketIterator(nextBucketIdx)
 	for {
 		b, err := bit.next()
@@ -38,15 +40,83 @@ func (it *ItemIterator) fetchItems(nextBucketIdx uint32) error {
 				// No more items in the bucket.
 				break
 			}
-			key, value, err := it.db.datalog.readKeyValue(sl)
+			rec, err := it.db.datalog.lookupRecord(sl, true)
 			if err != nil {
 				return err
 			}
-			key = cloneBytes(key)
-			value = cloneBytes(value)
-			it.queue = append(it.queue, item{key: key, value: value})
+			if rec.expired(now) {
+				continue
+			}
+			value, err := it.db.recordValue(rec)
+			if err != nil {
+				return err
+			}
+			it.queue = append(it.queue, item{key: cloneBytes(rec.key), value: cloneBytes(value)})
+		}
+	}
+}
+
+// fetchSnapshotItems adds snapshot items to the iterator queue from a snapshot bucket located at nextBucketIdx.
+// Since the index only grows after the snapshot was created, the snapshot bucket items are spread between the same
+// bucket and the buckets split from it.
+func (it *ItemIterator) fetchSnapshotItems(nextBucketIdx uint32) error {
+	s := it.snap
+	step := uint64(1) << s.bucketBits(nextBucketIdx)
+	for bidx := uint64(nextBucketIdx); bidx < uint64(it.db.index.numBuckets); bidx += step {
+		bit := it.db.index.newBucketIterator(uint32(bidx))
+		for {
+			b, err := bit.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			for i := 0; i < slotsPerBucket; i++ {
+				sl := b.slots[i]
+				if sl.offset == 0 {
+					// No more items in the bucket.
+					break
+				}
+				rec, err := it.db.datalog.lookupRecord(sl, true)
+				if err != nil {
+					return err
+				}
+				if _, ok := s.entries[string(rec.key)]; ok {
+					// The key was modified after the snapshot was created.
+					continue
+				}
+				if rec.expired(s.now) {
+					continue
+				}
+				value, err := it.db.recordValue(rec)
+				if err != nil {
+					return err
+				}
+				it.queue = append(it.queue, item{key: cloneBytes(rec.key), value: cloneBytes(value)})
+			}
 		}
 	}
+	// Add the original items of keys modified after the snapshot was created.
+	for _, k := range s.bucketEntries[nextBucketIdx] {
+		e := s.entries[k]
+		if !e.exists {
+			continue
+		}
+		rec, err := it.db.datalog.lookupRecord(e.slot, true)
+		if err != nil {
+			return err
+		}
+		if rec.expired(s.now) {
+			continue
+		}
+		value, err := it.db.recordValue(rec)
+		if err != nil {
+			return err
+		}
+		it.queue = append(it.queue, item{key: cloneBytes(rec.key), value: cloneBytes(value)})
+	}
+	return nil
 }
 
 // Next returns the next key-value pair if available, otherwise it returns ErrIterationDone error.
@@ -57,9 +127,19 @@ func (it *ItemIterator) Next() ([]byte, []byte, error) {
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
 
+	numBuckets := it.db.index.numBuckets
+	fetchItems := it.fetchItems
+	if it.snap != nil {
+		if it.snap.closed {
+			return nil, nil, ErrSnapshotClosed
+		}
+		numBuckets = it.snap.numBuckets
+		fetchItems = it.fetchSnapshotItems
+	}
+
 	// The iterator queue is empty and we have more buckets to check.
-	for len(it.queue) == 0 && it.nextBucketIdx < it.db.index.numBuckets {
-		if err := it.fetchItems(it.nextBucketIdx); err != nil {
+	for len(it.queue) == 0 && it.nextBucketIdx < numBuckets {
+		if err := fetchItems(it.nextBucketIdx); err != nil {
 			return nil, nil, err
 		}
 		it.nextBucketIdx++
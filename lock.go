@@ -7,9 +7,62 @@ import (
 )
 
 const (
-	lockName = "lock"
+	lockName   = "lock"
+	rwLockName = "rwlock"
 )
 
+// createLockFile acquires the DB lock files.
+//
+// The DB opened in read-write mode holds exclusive locks on both the rwlock and the lock files.
+// The lock file is left behind when the DB isn't closed properly, the returned bool reports whether the lock file
+// already existed.
+// The DB opened in read-only mode holds a shared lock on the rwlock file and only checks whether the lock file exists.
+// The rwlock file is never used to detect whether the DB needs recovery, read-only instances terminated without
+// unlocking it don't affect the read-write instances.
 func createLockFile(opts *Options) (fs.LockFile, bool, error) {
-	return opts.FileSystem.CreateLockFile(lockName, os.FileMode(0644))
+	fsys := opts.FileSystem
+	if opts.ReadOnly {
+		var rwLock fs.LockFile
+		var err error
+		if sl, ok := fsys.(fs.SharedLocker); ok {
+			rwLock, _, err = sl.CreateSharedLockFile(rwLockName, os.FileMode(0644))
+		} else {
+			rwLock, _, err = fsys.CreateLockFile(rwLockName, os.FileMode(0644))
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := fsys.Stat(lockName); err != nil {
+			if !os.IsNotExist(err) {
+				_ = rwLock.Unlock()
+				return nil, false, err
+			}
+			return rwLock, false, nil
+		}
+		return rwLock, true, nil
+	}
+
+	rwLock, _, err := fsys.CreateLockFile(rwLockName, os.FileMode(0644))
+	if err != nil {
+		return nil, false, err
+	}
+	lock, acquiredExisting, err := fsys.CreateLockFile(lockName, os.FileMode(0644))
+	if err != nil {
+		_ = rwLock.Unlock()
+		return nil, false, err
+	}
+	return lockFiles{lock, rwLock}, acquiredExisting, nil
+}
+
+// lockFiles unlocks multiple lock files in order.
+type lockFiles []fs.LockFile
+
+func (lf lockFiles) Unlock() error {
+	var firstErr error
+	for _, l := range lf {
+		if err := l.Unlock(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
@@ -1,15 +1,83 @@
 package pogreb
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 )
 
 var logger = log.New(os.Stderr, "pogreb: ", 0)
 
-// SetLogger sets the global logger.
+// SetLogger sets the global logger used by DBs opened without Options.Logger.
 func SetLogger(l *log.Logger) {
 	if l != nil {
 		logger = l
 	}
 }
+
+// globalLogHandler is a slog.Handler writing records to the global logger set by SetLogger.
+// Records are formatted as the message followed by key=value attributes.
+type globalLogHandler struct {
+	attrs  string // Preformatted attributes.
+	prefix string // Key prefix of the open groups.
+}
+
+func (h *globalLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *globalLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+	logger.Print(b.String())
+	return nil
+}
+
+func (h *globalLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+	return &globalLogHandler{attrs: b.String(), prefix: h.prefix}
+}
+
+func (h *globalLogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &globalLogHandler{attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+	b.WriteString(" ")
+	b.WriteString(prefix)
+	b.WriteString(a.Key)
+	b.WriteString("=")
+	v := a.Value.String()
+	if v == "" || strings.ContainsAny(v, " =\"") {
+		v = strconv.Quote(v)
+	}
+	b.WriteString(v)
+}
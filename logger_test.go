@@ -0,0 +1,65 @@
+package pogreb
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestOptionsLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	opts := &Options{
+		Logger: slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})),
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+	assert.Nil(t, db.Close())
+
+	// Corrupt the segment and recover.
+	f, err := openFile(testFS, testDBName+"/00000-1.psg", openFileFlags{})
+	assert.Nil(t, err)
+	_, err = f.append([]byte{1, 2, 3})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	assert.Nil(t, touchFile(testFS, testDBName+"/"+lockName))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	out := buf.String()
+	for _, line := range []string{
+		"level=INFO msg=\"started recovery\" db=test.db\n",
+		"level=WARN msg=\"truncated corrupted segment\" db=test.db segment=00000-1.psg offset=524\n",
+		"level=INFO msg=\"successfully recovered database\" db=test.db\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expected %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestGlobalLogHandler(t *testing.T) {
+	prevLogger := logger
+	defer SetLogger(prevLogger)
+	buf := &bytes.Buffer{}
+	SetLogger(log.New(buf, "", 0))
+
+	l := slog.New(&globalLogHandler{}).With("db", "test.db")
+	l.Info("message", "n", 1)
+	l.WithGroup("g").Error("error message", slog.Group("sub", "a", "b"), "err", errors.New("x y"), "empty", "")
+	l.Debug("debug message")
+	assert.Equal(t, "message db=test.db n=1\nerror message db=test.db g.sub.a=b g.err=\"x y\" g.empty=\"\"\n", buf.String())
+}
@@ -0,0 +1,164 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+const (
+	// maxMergeDepth is the maximum number of merge records in a merge chain.
+	// Longer chains are folded into a put record.
+	maxMergeDepth = 64
+)
+
+// MergeOperator combines the existing value of a key with a merge operand.
+// Merge operands are written by DB.Merge without reading the existing value.
+// The operands are applied in the order they were written when the value is read or compacted.
+type MergeOperator interface {
+	// Merge returns the result of merging the operand into the existing value.
+	// The existing value is nil if the key doesn't exist.
+	// Merge must not modify or retain existing and operand.
+	Merge(key []byte, existing []byte, operand []byte) ([]byte, error)
+}
+
+type int64AddOperator struct{}
+
+func (int64AddOperator) Merge(key []byte, existing []byte, operand []byte) ([]byte, error) {
+	if len(operand) != 8 || (existing != nil && len(existing) != 8) {
+		return nil, ErrInvalidMergeOperand
+	}
+	var n int64
+	if existing != nil {
+		n = int64(binary.LittleEndian.Uint64(existing))
+	}
+	n += int64(binary.LittleEndian.Uint64(operand))
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, uint64(n))
+	return value, nil
+}
+
+type appendOperator struct{}
+
+func (appendOperator) Merge(key []byte, existing []byte, operand []byte) ([]byte, error) {
+	value := make([]byte, 0, len(existing)+len(operand))
+	value = append(value, existing...)
+	return append(value, operand...), nil
+}
+
+var (
+	// Int64AddOperator adds the operand to the existing value.
+	// Values and operands are int64 numbers encoded as 8-byte little-endian integers, a missing value is treated as 0.
+	Int64AddOperator MergeOperator = int64AddOperator{}
+
+	// AppendOperator appends the operand to the existing value.
+	AppendOperator MergeOperator = appendOperator{}
+)
+
+// recordValue returns the value of the record.
+// The value of a merge record is computed by merging the operands of the merge chain into the base value.
+// The returned value is valid only while the DB read lock is held.
+func (db *DB) recordValue(rec record) ([]byte, error) {
+	if rec.rtype != recordTypeMerge {
+		return rec.value, nil
+	}
+	if db.opts.MergeOperator == nil {
+		return nil, ErrNoMergeOperator
+	}
+	key := rec.key
+	var operands [][]byte
+	for rec.rtype == recordTypeMerge {
+		operands = append(operands, rec.value)
+		segID, offset, ok := rec.mergePrev()
+		if !ok {
+			rec = record{}
+			break
+		}
+		var err error
+		if rec, err = db.datalog.readRecordAt(segID, offset); err != nil {
+			return nil, err
+		}
+	}
+	value := rec.value
+	for i := len(operands) - 1; i >= 0; i-- {
+		var err error
+		if value, err = db.opts.MergeOperator.Merge(key, value, operands[i]); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// Merge merges the operand into the value of the given key using Options.MergeOperator.
+// The operand is written to the DB without reading the existing value, the merge operator is applied when the value
+// is read.
+// The merged value keeps the expiration time of the existing value.
+func (db *DB) Merge(key []byte, operand []byte) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.opts.MergeOperator == nil {
+		return ErrNoMergeOperator
+	}
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLarge
+	}
+	if len(operand) > MaxValueLength {
+		return ErrValueTooLarge
+	}
+	defer db.metrics.MergeLatency.observeSince(time.Now())
+	db.metrics.Merges.Add(1)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.following {
+		return ErrReadOnly
+	}
+
+	l, err := db.lookupKey(key, false)
+	if err != nil {
+		return err
+	}
+	var prev slot
+	var depth uint16
+	var expiresAt int64
+	if l.live {
+		prev = l.slot
+		depth = l.rec.mergeDepth() + 1
+		expiresAt = l.rec.expiresAt()
+	}
+
+	size := recordSize(uint32(len(key)+len(operand)), recordTypeMerge)
+	if l.live && (depth >= maxMergeDepth || !db.datalog.canAppend(prev.segmentID, size)) {
+		// Merge chains don't span multiple segments, fold the merge chain into a put record instead.
+		rec, err := db.datalog.readRecord(l.slot, true)
+		if err != nil {
+			return err
+		}
+		existing, err := db.recordValue(rec)
+		if err != nil {
+			return err
+		}
+		value, err := db.opts.MergeOperator.Merge(key, existing, operand)
+		if err != nil {
+			return err
+		}
+		if len(value) > MaxValueLength {
+			return ErrValueTooLarge
+		}
+		if err := db.putAt(l, key, value, expiresAt); err != nil {
+			return err
+		}
+	} else {
+		segID, offset, err := db.datalog.merge(key, operand, prev, depth, expiresAt)
+		if err != nil {
+			return err
+		}
+		if err := db.insertAt(l, key, segID, offset, uint32(len(operand))); err != nil {
+			return err
+		}
+	}
+
+	if db.syncWrites {
+		return db.sync()
+	}
+	return nil
+}
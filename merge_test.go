@@ -0,0 +1,232 @@
+package pogreb
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func int64Bytes(n int64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(n))
+	return b
+}
+
+func TestMergeOperators(t *testing.T) {
+	v, err := Int64AddOperator.Merge(nil, nil, int64Bytes(2))
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(2), v)
+	v, err = Int64AddOperator.Merge(nil, int64Bytes(2), int64Bytes(-3))
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(-1), v)
+	_, err = Int64AddOperator.Merge(nil, []byte{1}, int64Bytes(1))
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+	_, err = Int64AddOperator.Merge(nil, nil, []byte{1})
+	assert.Equal(t, ErrInvalidMergeOperand, err)
+
+	v, err = AppendOperator.Merge(nil, nil, []byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, v)
+	existing := []byte{1, 2}
+	v, err = AppendOperator.Merge(nil, existing[:1], []byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 3}, v)
+	assert.Equal(t, []byte{1, 2}, existing)
+}
+
+func TestMerge(t *testing.T) {
+	opts := &Options{FileSystem: testFS, MergeOperator: Int64AddOperator}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Merge([]byte{1}, int64Bytes(1)))
+	}
+	assert.Nil(t, db.Put([]byte{2}, int64Bytes(5)))
+	assert.Nil(t, db.Merge([]byte{2}, int64Bytes(2)))
+	assert.Equal(t, int64(11), db.Metrics().Merges.Value())
+
+	assert.Equal(t, uint32(2), db.Count())
+	// Nine merge records (36 bytes each) and one put record (19 bytes) are superseded by merge records.
+	assert.Equal(t, &segmentMeta{PutRecords: 1, MergeRecords: 11, DeletedKeys: 10, DeletedBytes: 343}, db.datalog.segments[0].meta)
+
+	check := func() {
+		v, err := db.Get([]byte{1})
+		assert.Nil(t, err)
+		assert.Equal(t, int64Bytes(10), v)
+		v, err = db.Get([]byte{2})
+		assert.Nil(t, err)
+		assert.Equal(t, int64Bytes(7), v)
+		has, err := db.Has([]byte{1})
+		assert.Nil(t, err)
+		assert.Equal(t, true, has)
+
+		items := make(map[byte][]byte)
+		it := db.Items()
+		for {
+			key, value, err := it.Next()
+			if err == ErrIterationDone {
+				break
+			}
+			assert.Nil(t, err)
+			items[key[0]] = value
+		}
+		assert.Equal(t, map[byte][]byte{1: int64Bytes(10), 2: int64Bytes(7)}, items)
+	}
+	check()
+
+	// Reopen.
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	check()
+
+	// Recover.
+	assert.Nil(t, db.Close())
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	check()
+	assert.Equal(t, &segmentMeta{PutRecords: 1, MergeRecords: 11, DeletedKeys: 10, DeletedBytes: 343}, db.datalog.segments[0].meta)
+
+	// Overwriting and deleting merged keys.
+	assert.Nil(t, db.Put([]byte{1}, int64Bytes(1)))
+	assert.Nil(t, db.Delete([]byte{2}))
+	assert.Nil(t, db.Merge([]byte{2}, int64Bytes(3)))
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(1), v)
+	v, err = db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(3), v)
+
+	assert.Nil(t, db.Close())
+
+	// Merge records can't be read without the merge operator.
+	db, err = Open(testDBName, &Options{FileSystem: testFS})
+	assert.Nil(t, err)
+	assert.Equal(t, ErrNoMergeOperator, db.Merge([]byte{1}, int64Bytes(1)))
+	_, err = db.Get([]byte{2})
+	assert.Equal(t, ErrNoMergeOperator, err)
+	assert.Nil(t, db.Close())
+}
+
+func TestMergeFold(t *testing.T) {
+	db, err := createTestDB(&Options{MergeOperator: AppendOperator})
+	assert.Nil(t, err)
+
+	// Merge chains longer than maxMergeDepth are folded into put records.
+	var expected []byte
+	for i := 0; i <= maxMergeDepth; i++ {
+		assert.Nil(t, db.Merge([]byte{1}, []byte{byte(i)}))
+		expected = append(expected, byte(i))
+	}
+	meta := db.datalog.segments[0].meta
+	assert.Equal(t, uint32(maxMergeDepth), meta.MergeRecords)
+	assert.Equal(t, uint32(1), meta.PutRecords)
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, v)
+
+	assert.Nil(t, db.Merge([]byte{1}, []byte{0}))
+	v, err = db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, append(expected, 0), v)
+	assert.Equal(t, uint32(maxMergeDepth+1), db.datalog.segments[0].meta.MergeRecords)
+
+	assert.Nil(t, db.Close())
+}
+
+func TestMergeCompaction(t *testing.T) {
+	opts := &Options{
+		MergeOperator:              AppendOperator,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Merge([]byte{1}, []byte{byte(i)}))
+		expected = append(expected, byte(i))
+	}
+	assert.Nil(t, db.Merge([]byte{3}, []byte{1}))
+	for countSegments(t, db) == 1 {
+		assert.Nil(t, db.Put([]byte{2}, []byte{2}))
+	}
+
+	// Merge chains don't span multiple segments.
+	assert.Nil(t, db.Merge([]byte{3}, []byte{2}))
+	assert.Nil(t, db.Merge([]byte{2}, []byte{3}))
+	assert.Equal(t, &segmentMeta{PutRecords: 2, MergeRecords: 1, DeletedKeys: 1, DeletedBytes: 12}, db.datalog.segments[1].meta)
+
+	s := db.Snapshot()
+
+	// Compaction folds merge chains into put records.
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, cr.CompactedSegments)
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if !seg.compacted {
+			assert.Equal(t, uint32(0), seg.meta.MergeRecords)
+		}
+	}
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, v)
+	v, err = db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2, 3}, v)
+	v, err = db.Get([]byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, v)
+
+	// The snapshot keeps reading the original merge chain.
+	v, err = s.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, v)
+	assert.Nil(t, s.Close())
+
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	v, err = db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, v)
+	assert.Nil(t, db.Close())
+}
+
+func TestMergeTTL(t *testing.T) {
+	clock := newTestClock()
+	db, err := createTestDB(&Options{MergeOperator: Int64AddOperator, now: clock.now})
+	assert.Nil(t, err)
+
+	assert.Nil(t, db.PutWithTTL([]byte{1}, int64Bytes(1), time.Second))
+	assert.Nil(t, db.Merge([]byte{1}, int64Bytes(1)))
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(2), v)
+
+	// Merged values expire together with the base value.
+	clock.advance(time.Second)
+	v, err = db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+
+	// Merging into an expired key starts a new value.
+	assert.Nil(t, db.Merge([]byte{1}, int64Bytes(5)))
+	v, err = db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(5), v)
+	clock.advance(time.Hour)
+	v, err = db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(5), v)
+
+	assert.Nil(t, db.Close())
+}
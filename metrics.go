@@ -1,11 +1,178 @@
 package pogreb
 
-import "expvar"
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
 
 // Metrics holds the DB metrics.
 type Metrics struct {
 	Puts           expvar.Int
 	Dels           expvar.Int
+	Merges         expvar.Int
 	Gets           expvar.Int
 	HashCollisions expvar.Int
+
+	BytesWritten   expvar.Int // Size of the records written to the write-ahead log, including compaction writes.
+	BytesRead      expvar.Int // Size of the records read from the write-ahead log by reads and iterators.
+	Compactions    expvar.Int // Number of compaction runs.
+	ReclaimedBytes expvar.Int // Disk space reclaimed by compaction.
+	Recoveries     expvar.Int // Number of recoveries after the DB wasn't closed properly.
+	GetLatency     Histogram  // Get, GetAppend, GetReader, Has and View.
+	GetManyLatency Histogram  // GetMany and GetManyAppend, one observation per call.
+	PutLatency     Histogram  // Put, PutWithTTL, PutReader, CompareAndSwap and PutIfAbsent.
+	DeleteLatency  Histogram  // Delete and DeleteIfEquals.
+	MergeLatency   Histogram  // Merge.
+	WriteLatency   Histogram  // Write, one observation per batch.
+	SyncLatency    Histogram
+}
+
+// latencyBuckets are the upper bounds of the Histogram buckets.
+var latencyBuckets = [...]time.Duration{
+	time.Microsecond, 2500 * time.Nanosecond, 5 * time.Microsecond,
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram is a latency histogram with fixed buckets ranging from 1µs to 10s.
+// It is safe for concurrent use.
+type Histogram struct {
+	counts [len(latencyBuckets) + 1]int64 // The last bucket counts observations exceeding all upper bounds.
+	count  int64
+	sum    int64
+}
+
+// HistogramBucket is a cumulative Histogram bucket.
+type HistogramBucket struct {
+	UpperBound time.Duration
+	Count      int64 // Number of observations less than or equal to UpperBound.
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	atomic.AddInt64(&h.count, 1)
+}
+
+// observeSince adds the time elapsed since start to the histogram.
+func (h *Histogram) observeSince(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+// Sum returns the sum of all observations.
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.sum))
+}
+
+// Buckets returns the cumulative bucket counts. Observations exceeding the largest upper bound are counted only by
+// Count.
+func (h *Histogram) Buckets() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(latencyBuckets))
+	var cumulative int64
+	for i, ub := range latencyBuckets {
+		cumulative += atomic.LoadInt64(&h.counts[i])
+		buckets[i] = HistogramBucket{UpperBound: ub, Count: cumulative}
+	}
+	return buckets
+}
+
+// String implements the expvar.Var interface.
+func (h *Histogram) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `{"count": %d, "sum": %d, "buckets": {`, h.Count(), int64(h.Sum()))
+	for i, bucket := range h.Buckets() {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, `"%s": %d`, bucket.UpperBound, bucket.Count)
+	}
+	b.WriteString("}}")
+	return b.String()
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	counters := []struct {
+		name string
+		help string
+		v    *expvar.Int
+	}{
+		{"pogreb_puts_total", "Number of put operations.", &m.Puts},
+		{"pogreb_deletes_total", "Number of delete operations.", &m.Dels},
+		{"pogreb_merges_total", "Number of merge operations.", &m.Merges},
+		{"pogreb_gets_total", "Number of get operations.", &m.Gets},
+		{"pogreb_hash_collisions_total", "Number of key hash collisions.", &m.HashCollisions},
+		{"pogreb_written_bytes_total", "Size of the records written to the write-ahead log, including compaction writes.", &m.BytesWritten},
+		{"pogreb_read_bytes_total", "Size of the records read from the write-ahead log by reads and iterators.", &m.BytesRead},
+		{"pogreb_compactions_total", "Number of compaction runs.", &m.Compactions},
+		{"pogreb_compaction_reclaimed_bytes_total", "Disk space reclaimed by compaction.", &m.ReclaimedBytes},
+		{"pogreb_recoveries_total", "Number of recoveries after the DB wasn't closed properly.", &m.Recoveries},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.v.Value())
+	}
+	histograms := []struct {
+		name string
+		help string
+		h    *Histogram
+	}{
+		{"pogreb_get_duration_seconds", "Latency of get operations.", &m.GetLatency},
+		{"pogreb_get_many_duration_seconds", "Latency of batched get operations.", &m.GetManyLatency},
+		{"pogreb_put_duration_seconds", "Latency of put operations.", &m.PutLatency},
+		{"pogreb_delete_duration_seconds", "Latency of delete operations.", &m.DeleteLatency},
+		{"pogreb_merge_duration_seconds", "Latency of merge operations.", &m.MergeLatency},
+		{"pogreb_write_batch_duration_seconds", "Latency of batch writes.", &m.WriteLatency},
+		{"pogreb_sync_duration_seconds", "Latency of syncing the write-ahead log to the file system.", &m.SyncLatency},
+	}
+	for _, h := range histograms {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+		// Load the count first, observations added later don't break the monotonicity of the buckets.
+		count := h.h.Count()
+		for _, bucket := range h.h.Buckets() {
+			n := bucket.Count
+			if n > count {
+				n = count
+			}
+			fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", h.name, formatSeconds(bucket.UpperBound), n)
+		}
+		fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", h.name, count)
+		fmt.Fprintf(bw, "%s_sum %s\n", h.name, formatSeconds(h.h.Sum()))
+		fmt.Fprintf(bw, "%s_count %d\n", h.name, count)
+	}
+	return bw.Flush()
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+// Handler returns an http.Handler serving the metrics in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = m.WritePrometheus(w)
+	})
 }
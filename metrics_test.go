@@ -0,0 +1,132 @@
+package pogreb
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := &Histogram{}
+	h.Observe(500 * time.Nanosecond)
+	h.Observe(time.Microsecond)
+	h.Observe(3 * time.Millisecond)
+	h.Observe(time.Minute)
+	assert.Equal(t, int64(4), h.Count())
+	assert.Equal(t, time.Minute+3*time.Millisecond+1500*time.Nanosecond, h.Sum())
+
+	buckets := h.Buckets()
+	assert.Equal(t, len(latencyBuckets), len(buckets))
+	assert.Equal(t, HistogramBucket{UpperBound: time.Microsecond, Count: 2}, buckets[0])
+	assert.Equal(t, HistogramBucket{UpperBound: 2500 * time.Microsecond, Count: 2}, buckets[10])
+	assert.Equal(t, HistogramBucket{UpperBound: 5 * time.Millisecond, Count: 3}, buckets[11])
+	assert.Equal(t, HistogramBucket{UpperBound: 10 * time.Second, Count: 3}, buckets[len(buckets)-1])
+
+	var v struct {
+		Count   int64
+		Sum     int64
+		Buckets map[string]int64
+	}
+	assert.Nil(t, json.Unmarshal([]byte(h.String()), &v))
+	assert.Equal(t, int64(4), v.Count)
+	assert.Equal(t, int64(h.Sum()), v.Sum)
+	assert.Equal(t, int64(3), v.Buckets["5ms"])
+}
+
+func TestMetrics(t *testing.T) {
+	opts := &Options{
+		BackgroundSyncInterval:     -1,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+		MergeOperator:              AppendOperator,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Delete([]byte{byte(i)}))
+	}
+	m := db.Metrics()
+	assert.Equal(t, int64(50*12+10*11), m.BytesWritten.Value())
+	// Reading existing records to overwrite or delete keys isn't counted.
+	assert.Equal(t, int64(0), m.BytesRead.Value())
+	v, err := db.Get([]byte{20})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{20}, v)
+	bytesRead := m.BytesRead.Value()
+	assert.Equal(t, true, bytesRead >= 8) // Key and value of the found record.
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	// Compaction rewrites the live records.
+	assert.Equal(t, true, m.BytesWritten.Value() > 50*12+10*11)
+	assert.Equal(t, bytesRead, m.BytesRead.Value())
+	assert.Equal(t, int64(1), m.Compactions.Value())
+	assert.Equal(t, int64(cr.ReclaimedBytes), m.ReclaimedBytes.Value())
+	assert.Equal(t, int64(0), m.Recoveries.Value())
+	assert.Equal(t, int64(50), m.PutLatency.Count())
+	assert.Equal(t, int64(10), m.DeleteLatency.Count())
+	assert.Equal(t, int64(1), m.GetLatency.Count())
+	assert.Equal(t, int64(60), m.SyncLatency.Count())
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, m.WritePrometheus(buf))
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE pogreb_puts_total counter\npogreb_puts_total 50\n",
+		"pogreb_deletes_total 10\n",
+		"pogreb_compactions_total 1\n",
+		"# TYPE pogreb_get_duration_seconds histogram\n",
+		"pogreb_get_duration_seconds_bucket{le=\"1e-06\"} ",
+		"pogreb_get_duration_seconds_bucket{le=\"+Inf\"} 1\n",
+		"pogreb_put_duration_seconds_count 50\n",
+		"pogreb_sync_duration_seconds_bucket{le=\"10\"} ",
+	} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expected %q in:\n%s", line, out)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, out, rec.Body.String())
+
+	// Conditional writes, batches, merges and multi-key reads are covered by the latency histograms.
+	_, err = db.PutIfAbsent([]byte{0}, []byte{0})
+	assert.Nil(t, err)
+	_, err = db.CompareAndSwap([]byte{0}, []byte{0}, []byte{1})
+	assert.Nil(t, err)
+	_, err = db.DeleteIfEquals([]byte{0}, []byte{1})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge([]byte{1}, []byte{1}))
+	b := NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{2}, []byte{2}))
+	assert.Nil(t, db.Write(b))
+	_, err = db.GetMany([][]byte{{20}, {21}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(52), m.PutLatency.Count())
+	assert.Equal(t, int64(11), m.DeleteLatency.Count())
+	assert.Equal(t, int64(1), m.MergeLatency.Count())
+	assert.Equal(t, int64(1), m.WriteLatency.Count())
+	assert.Equal(t, int64(1), m.GetLatency.Count())
+	assert.Equal(t, int64(1), m.GetManyLatency.Count())
+
+	assert.Nil(t, db.Close())
+
+	// Reopen after a crash.
+	assert.Nil(t, touchFile(testFS, testDBName+"/"+lockName))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), db.Metrics().Recoveries.Value())
+	assert.Nil(t, db.Close())
+}
@@ -1,10 +1,12 @@
 package pogreb
 
 import (
+	"log/slog"
 	"math"
 	"time"
 
 	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/errors"
 )
 
 // Options holds the optional DB parameters.
@@ -22,18 +24,91 @@ type Options struct {
 	// Default: 0
 	BackgroundCompactionInterval time.Duration
 
+	// MaxSegmentSize sets the maximum size of a write-ahead log segment file in bytes.
+	//
+	// Writes are switched to a new segment when the current segment is full. Smaller segments are compacted faster and
+	// reclaim disk space sooner, but the DB can't hold more than 32767 segments.
+	// The value must be larger than 512 bytes, the size of the segment header.
+	// Default: 4 GiB
+	MaxSegmentSize uint32
+
+	// CompactionMinSegmentSize sets the minimum size of a segment in bytes to be eligible for compaction.
+	//
+	// The value must not exceed MaxSegmentSize. Ignored when CompactionPolicy is set.
+	// Default: 32 MiB or MaxSegmentSize, whichever is smaller
+	CompactionMinSegmentSize uint32
+
+	// CompactionMinFragmentation sets the minimum ratio of deleted and expired records size to the segment size for the
+	// segment to be eligible for compaction.
+	//
+	// The value must be in the (0, 1] range. Ignored when CompactionPolicy is set.
+	// Default: 0.5
+	CompactionMinFragmentation float32
+
+	// CompactionPolicy selects segments for compaction.
+	//
+	// Default: ThresholdCompactionPolicy using CompactionMinSegmentSize and CompactionMinFragmentation.
+	CompactionPolicy CompactionPolicy
+
+	// CompactionRateLimit limits the compaction I/O to the given number of bytes of segment data per second.
+	//
+	// Setting the value to 0 disables the rate limiting.
+	// Default: 0
+	CompactionRateLimit int64
+
+	// CompactionRateLimiter sets the RateLimiter throttling the compaction I/O.
+	// It overrides CompactionRateLimit.
+	//
+	// Default: nil, a limiter created with NewRateLimiter(CompactionRateLimit) if CompactionRateLimit is set.
+	CompactionRateLimiter RateLimiter
+
+	// CompactionBatchSize sets the maximum number of records copied by compaction while holding the DB write lock.
+	//
+	// Larger batches make compaction acquire the write lock less often, but hold it longer.
+	// The compaction rate limit is applied between batches.
+	// Default: 1
+	CompactionBatchSize int
+
+	// ReadOnly opens the DB in read-only mode.
+	//
+	// The read-only DB doesn't modify the DB files and holds a shared lock, allowing the DB to be opened by multiple
+	// read-only instances at the same time. The DB can't be opened in read-write mode while the lock is held.
+	// When the DB is already open in read-write mode, the read-only DB doesn't hold the lock and rebuilds the index in
+	// memory from the segments. It reads the data written before it was opened and doesn't see the later changes.
+	// The segments aren't memory-mapped in this case, reads may fail if the read-write instance removes or truncates them.
+	// Opening the DB fails if the DB wasn't closed properly.
+	// Default: false
+	ReadOnly bool
+
+	// MergeOperator sets the merge operator used by DB.Merge.
+	//
+	// The DB must always be opened with the same merge operator once DB.Merge was called.
+	// Default: nil
+	MergeOperator MergeOperator
+
+	// Logger sets the structured logger used to log recovery, compaction and background errors.
+	//
+	// Log records include the DB path in the "db" attribute.
+	// Default: the global logger set by SetLogger.
+	Logger *slog.Logger
+
+	// EventListener receives notifications about compaction, segment rotation, recovery, background errors and index
+	// splits.
+	//
+	// Default: NoopEventListener.
+	EventListener EventListener
+
 	// FileSystem sets the file system implementation.
 	//
 	// Default: fs.OSMMap.
 	FileSystem fs.FileSystem
-	rootFS fs.FileSystem
 
-	maxSegmentSize             uint32
-	compactionMinSegmentSize   uint32
-	compactionMinFragmentation float32
+	rootFS fs.FileSystem
+	path   string
+	now    func() time.Time
 }
 
-func (src *Options) copyWithDefaults(path string) *Options {
+func (src *Options) copyWithDefaults(path string) (*Options, error) {
 	opts := Options{}
 	if src != nil {
 		opts = *src
@@ -42,15 +117,66 @@ func (src *Options) copyWithDefaults(path string) *Options {
 		opts.FileSystem = fs.DefaultFileSystem()
 	}
 	opts.rootFS = opts.FileSystem
+	opts.path = path
 	opts.FileSystem = fs.Sub(opts.FileSystem, path)
-	if opts.maxSegmentSize == 0 {
-		opts.maxSegmentSize = math.MaxUint32
+	if opts.MaxSegmentSize == 0 {
+		opts.MaxSegmentSize = math.MaxUint32
+	}
+	if opts.MaxSegmentSize <= headerSize {
+		return nil, errors.Wrapf(ErrInvalidOptions, "MaxSegmentSize %d must be larger than %d", opts.MaxSegmentSize, headerSize)
+	}
+	if opts.CompactionMinSegmentSize == 0 {
+		opts.CompactionMinSegmentSize = min(32<<20, opts.MaxSegmentSize)
+	} else if opts.CompactionMinSegmentSize > opts.MaxSegmentSize {
+		return nil, errors.Wrapf(ErrInvalidOptions, "CompactionMinSegmentSize %d exceeds MaxSegmentSize %d",
+			opts.CompactionMinSegmentSize, opts.MaxSegmentSize)
+	}
+	if opts.CompactionMinFragmentation == 0 {
+		opts.CompactionMinFragmentation = 0.5
 	}
-	if opts.compactionMinSegmentSize == 0 {
-		opts.compactionMinSegmentSize = 32 << 20
+	if !(opts.CompactionMinFragmentation > 0 && opts.CompactionMinFragmentation <= 1) {
+		return nil, errors.Wrapf(ErrInvalidOptions, "CompactionMinFragmentation %v must be in the (0, 1] range",
+			opts.CompactionMinFragmentation)
 	}
-	if opts.compactionMinFragmentation == 0 {
-		opts.compactionMinFragmentation = 0.5
+	if opts.CompactionPolicy == nil {
+		opts.CompactionPolicy = ThresholdCompactionPolicy{
+			MinSegmentSize:   opts.CompactionMinSegmentSize,
+			MinFragmentation: opts.CompactionMinFragmentation,
+		}
 	}
+	if opts.CompactionRateLimit < 0 {
+		return nil, errors.Wrapf(ErrInvalidOptions, "CompactionRateLimit %d must not be negative", opts.CompactionRateLimit)
+	}
+	if opts.CompactionBatchSize < 0 {
+		return nil, errors.Wrapf(ErrInvalidOptions, "CompactionBatchSize %d must not be negative", opts.CompactionBatchSize)
+	}
+	if opts.CompactionRateLimiter == nil && opts.CompactionRateLimit > 0 {
+		limiter, err := NewRateLimiter(opts.CompactionRateLimit)
+		if err != nil {
+			return nil, err
+		}
+		opts.CompactionRateLimiter = limiter
+	}
+	if opts.CompactionBatchSize == 0 {
+		opts.CompactionBatchSize = 1
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(&globalLogHandler{})
+	}
+	opts.Logger = opts.Logger.With("db", path)
+	if opts.EventListener == nil {
+		opts.EventListener = NoopEventListener{}
+	}
+	if opts.now == nil {
+		opts.now = time.Now
+	}
+	return &opts, nil
+}
+
+// copyForMemIndex returns a copy of the options for the index kept in memory by the read-only DB.
+func (src *Options) copyForMemIndex() *Options {
+	opts := *src
+	opts.ReadOnly = false
+	opts.FileSystem = fs.NewMem()
 	return &opts
 }
@@ -0,0 +1,80 @@
+package pogreb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/errors"
+)
+
+// RateLimiter limits the rate of compaction I/O.
+type RateLimiter interface {
+	// WaitN blocks until n bytes can be processed or the context is done.
+	WaitN(ctx context.Context, n int) error
+}
+
+// tokenBucket is a RateLimiter allowing a sustained rate of bytes per second with bursts of up to a second worth of
+// bytes.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second.
+	tokens float64 // Available bytes, negative when WaitN requested more than available.
+	last   time.Time
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+}
+
+// NewRateLimiter returns a RateLimiter allowing bytesPerSecond bytes per second.
+// It returns ErrInvalidRateLimit if bytesPerSecond is not positive.
+func NewRateLimiter(bytesPerSecond int64) (RateLimiter, error) {
+	if bytesPerSecond <= 0 {
+		return nil, errors.Wrapf(ErrInvalidRateLimit, "%d bytes per second", bytesPerSecond)
+	}
+	return newTokenBucket(bytesPerSecond, time.Now, sleepContext), nil
+}
+
+func newTokenBucket(bytesPerSecond int64, now func() time.Time, sleep func(context.Context, time.Duration) error) *tokenBucket {
+	rate := float64(bytesPerSecond)
+	return &tokenBucket{
+		rate:   rate,
+		tokens: rate,
+		last:   now(),
+		now:    now,
+		sleep:  sleep,
+	}
+}
+
+// WaitN implements RateLimiter.
+// Requests larger than the burst size are allowed, the following requests wait until the debt is paid off.
+func (tb *tokenBucket) WaitN(ctx context.Context, n int) error {
+	tb.mu.Lock()
+	now := tb.now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.rate {
+		tb.tokens = tb.rate
+	}
+	tb.last = now
+	tb.tokens -= float64(n)
+	var wait time.Duration
+	if tb.tokens < 0 {
+		wait = time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	}
+	tb.mu.Unlock()
+	if wait == 0 {
+		return ctx.Err()
+	}
+	return tb.sleep(ctx, wait)
+}
+
+// sleepContext pauses for the duration or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package pogreb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	clock := newTestClock()
+	var slept []time.Duration
+	sleep := func(ctx context.Context, d time.Duration) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		slept = append(slept, d)
+		clock.advance(d)
+		return nil
+	}
+	tb := newTokenBucket(1000, clock.now, sleep)
+	ctx := context.Background()
+
+	// The burst is allowed without waiting.
+	assert.Nil(t, tb.WaitN(ctx, 600))
+	assert.Nil(t, tb.WaitN(ctx, 400))
+	assert.Equal(t, 0, len(slept))
+
+	// The bucket is empty.
+	assert.Nil(t, tb.WaitN(ctx, 500))
+	assert.Equal(t, []time.Duration{500 * time.Millisecond}, slept)
+
+	// Tokens are refilled over time up to the burst size.
+	clock.advance(time.Hour)
+	assert.Nil(t, tb.WaitN(ctx, 1000))
+	assert.Equal(t, 1, len(slept))
+
+	// Requests larger than the burst size wait until the debt is paid off.
+	assert.Nil(t, tb.WaitN(ctx, 3000))
+	assert.Equal(t, 3*time.Second, slept[1])
+	assert.Nil(t, tb.WaitN(ctx, 1000))
+	assert.Equal(t, time.Second, slept[2])
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, tb.WaitN(cctx, 1000))
+	clock.advance(time.Hour)
+	assert.Equal(t, context.Canceled, tb.WaitN(cctx, 1))
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	for _, rate := range []int64{0, -1} {
+		rl, err := NewRateLimiter(rate)
+		assert.Nil(t, rl)
+		assert.Equal(t, true, errors.Is(err, ErrInvalidRateLimit))
+	}
+	rl, err := NewRateLimiter(1 << 20)
+	assert.Nil(t, err)
+	assert.Nil(t, rl.WaitN(context.Background(), 1<<10))
+}
+
+func TestSleepContext(t *testing.T) {
+	assert.Nil(t, sleepContext(context.Background(), time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, sleepContext(ctx, time.Hour))
+}
@@ -4,16 +4,17 @@ import (
 	"io"
 	"path/filepath"
 
-	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/errors"
 )
 
 const (
 	recoveryBackupExt = ".bac"
 )
 
-func backupNonsegmentFiles(fsys fs.FileSystem) error {
-	logger.Println("moving non-segment files...")
+func backupNonsegmentFiles(opts *Options) error {
+	opts.Logger.Info("moving non-segment files")
 
+	fsys := opts.FileSystem
 	files, err := fsys.ReadDir(".")
 	if err != nil {
 		return err
@@ -22,22 +23,23 @@ func backupNonsegmentFiles(fsys fs.FileSystem) error {
 	for _, file := range files {
 		name := file.Name()
 		ext := filepath.Ext(name)
-		if ext == segmentExt || name == lockName {
+		if ext == segmentExt || name == lockName || name == rwLockName {
 			continue
 		}
 		dst := name + recoveryBackupExt
 		if err := fsys.Rename(name, dst); err != nil {
 			return err
 		}
-		logger.Printf("moved %s to %s", name, dst)
+		opts.Logger.Info("moved file", "src", name, "dst", dst)
 	}
 
 	return nil
 }
 
-func removeRecoveryBackupFiles(fsys fs.FileSystem) error {
-	logger.Println("removing recovery backup files...")
+func removeRecoveryBackupFiles(opts *Options) error {
+	opts.Logger.Info("removing recovery backup files")
 
+	fsys := opts.FileSystem
 	files, err := fsys.ReadDir(".")
 	if err != nil {
 		return err
@@ -52,27 +54,57 @@ func removeRecoveryBackupFiles(fsys fs.FileSystem) error {
 		if err := fsys.Remove(name); err != nil {
 			return err
 		}
-		logger.Printf("removed %s", name)
+		opts.Logger.Info("removed file", "file", name)
 	}
 
 	return nil
 }
 
 // recoveryIterator iterates over records of all datalog segments in insertion order.
-// Corrupted segments are truncated to the last valid record.
+// Corrupted segments are truncated to the last valid record, unless the DB is open in read-only mode.
+// Batches are returned only when all of the batch records are valid, otherwise the segment is truncated to the beginning
+// of the batch.
 type recoveryIterator struct {
+	opts     *Options
 	segments []*segment
 	segit    *segmentIterator
+	batch    []record // Records of the last read batch, the batch record goes first.
 }
 
-func newRecoveryIterator(segments []*segment) *recoveryIterator {
+func newRecoveryIterator(segments []*segment, opts *Options) *recoveryIterator {
 	return &recoveryIterator{
+		opts:     opts,
 		segments: segments,
 	}
 }
 
+// readBatch reads the batch record followed by the batch records.
+func (it *recoveryIterator) readBatch(rec record) error {
+	batch := []record{rec}
+	for i := uint32(0); i < rec.batchSize(); i++ {
+		brec, err := it.segit.next()
+		if err == ErrIterationDone {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if brec.rtype != recordTypePut && brec.rtype != recordTypeDelete {
+			return ErrCorrupted
+		}
+		batch = append(batch, brec)
+	}
+	it.batch = batch
+	return nil
+}
+
 func (it *recoveryIterator) next() (record, error) {
 	for {
+		if len(it.batch) > 0 {
+			rec := it.batch[0]
+			it.batch = it.batch[1:]
+			return rec, nil
+		}
 		if it.segit == nil {
 			if len(it.segments) == 0 {
 				return record{}, ErrIterationDone
@@ -84,17 +116,28 @@ func (it *recoveryIterator) next() (record, error) {
 			}
 			it.segments = it.segments[1:]
 		}
+		validOffset := it.segit.offset
 		rec, err := it.segit.next()
-		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorrupted {
-			// Truncate file to the last valid offset.
-			if err := it.segit.f.Truncate(int64(it.segit.offset)); err != nil {
-				return record{}, err
+		if err == nil && rec.rtype == recordTypeBatch {
+			err = it.readBatch(rec)
+			if err == nil {
+				continue
 			}
-			fi, fierr := it.segit.f.Stat()
-			if fierr != nil {
-				return record{}, fierr
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF || errors.Is(err, ErrCorrupted) {
+			if it.opts.ReadOnly {
+				// The segment may be being written by the DB open in read-write mode.
+				// Ignore the records following the last valid offset.
+				it.segit.f.size = int64(validOffset)
+			} else {
+				// Truncate file to the last valid offset.
+				if err := it.segit.f.Truncate(int64(validOffset)); err != nil {
+					return record{}, err
+				}
+				it.segit.f.size = int64(validOffset)
+				it.opts.Logger.Warn("truncated corrupted segment", "segment", it.segit.f.name, "offset", validOffset)
+				it.opts.EventListener.OnRecoveryTruncate(it.segit.f.name, int64(validOffset))
 			}
-			logger.Printf("truncated segment %s to offset %d", fi.Name(), it.segit.offset)
 			err = ErrIterationDone
 		}
 		if err == ErrIterationDone {
@@ -108,12 +151,83 @@ func (it *recoveryIterator) next() (record, error) {
 	}
 }
 
-func (db *DB) recover() error {
-	logger.Println("started recovery")
-	logger.Println("rebuilding index...")
+// applyRecord updates the index and the segment metadata with the record read from the datalog.
+// Records expired at the given time are deleted from the index.
+func (db *DB) applyRecord(rec record, now int64) error {
+	meta := db.datalog.segments[rec.segmentID].meta
+	if rec.rtype == recordTypeBatch {
+		// Compaction removes batch records.
+		meta.DeletedBytes += uint32(len(rec.data))
+		return nil
+	}
+
+	h := db.hash(rec.key)
+	if rec.rtype == recordTypeDelete {
+		if err := db.del(h, rec.key, false); err != nil {
+			return err
+		}
+		meta.DeleteRecords++
+		meta.DeletedBytes += uint32(len(rec.data))
+		return nil
+	}
+
+	// Put and merge records.
+	sl := slot{
+		hash:      h,
+		segmentID: rec.segmentID,
+		keySize:   uint16(len(rec.key)),
+		valueSize: uint32(len(rec.value)),
+		offset:    rec.offset,
+	}
+	if err := db.put(sl, rec.key); err != nil {
+		return err
+	}
+	if rec.rtype == recordTypeMerge {
+		meta.MergeRecords++
+	} else {
+		meta.PutRecords++
+	}
+	if expiresAt := rec.expiresAt(); expiresAt != 0 {
+		meta.trackExpiring(uint32(len(rec.data)), expiresAt)
+		if rec.expired(now) {
+			// Expired keys are deleted from the index.
+			return db.del(h, rec.key, false)
+		}
+	}
+	return nil
+}
+
+func (db *DB) recover() (err error) {
+	db.opts.EventListener.OnRecoveryStart()
+	defer func() {
+		db.opts.EventListener.OnRecoveryEnd(err)
+	}()
+	db.opts.Logger.Info("started recovery")
+	db.opts.Logger.Info("rebuilding index")
+
+	if err := db.rebuildIndex(); err != nil {
+		return err
+	}
+
+	if err := removeRecoveryBackupFiles(db.opts); err != nil {
+		db.opts.Logger.Error("error removing recovery backup files", "err", err)
+	}
+
+	db.opts.Logger.Info("successfully recovered database")
 
+	return nil
+}
+
+// rebuildIndex rebuilds the index and the segment metadata from the records of all segments.
+// In read-only mode the segment files are not modified, the records following the last valid record of a segment are
+// ignored.
+func (db *DB) rebuildIndex() error {
+	now := db.now()
 	segments := db.datalog.segmentsBySequenceID()
-	it := newRecoveryIterator(segments)
+	for _, seg := range segments {
+		seg.meta = &segmentMeta{}
+	}
+	it := newRecoveryIterator(segments, db.opts)
 	for {
 		rec, err := it.next()
 		if err == ErrIterationDone {
@@ -123,26 +237,8 @@ func (db *DB) recover() error {
 			return err
 		}
 
-		h := db.hash(rec.key)
-		meta := db.datalog.segments[rec.segmentID].meta
-		if rec.rtype == recordTypePut {
-			sl := slot{
-				hash:      h,
-				segmentID: rec.segmentID,
-				keySize:   uint16(len(rec.key)),
-				valueSize: uint32(len(rec.value)),
-				offset:    rec.offset,
-			}
-			if err := db.put(sl, rec.key); err != nil {
-				return err
-			}
-			meta.PutRecords++
-		} else {
-			if err := db.del(h, rec.key, false); err != nil {
-				return err
-			}
-			meta.DeleteRecords++
-			meta.DeletedBytes += uint32(len(rec.data))
+		if err := db.applyRecord(rec, now); err != nil {
+			return err
 		}
 	}
 
@@ -150,12 +246,5 @@ func (db *DB) recover() error {
 	for i := 0; i < len(segments)-1; i++ {
 		segments[i].meta.Full = true
 	}
-
-	if err := removeRecoveryBackupFiles(db.opts.FileSystem); err != nil {
-		logger.Printf("error removing recovery backups files: %v", err)
-	}
-
-	logger.Println("successfully recovered database")
-
 	return nil
 }
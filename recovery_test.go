@@ -1,6 +1,8 @@
 package pogreb
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -126,9 +128,9 @@ func TestRecoveryDelete(t *testing.T) {
 func TestRecoveryCompaction(t *testing.T) {
 	opts := &Options{
 		FileSystem:                 testFS,
-		maxSegmentSize:             1024,
-		compactionMinSegmentSize:   512,
-		compactionMinFragmentation: 0.2,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   512,
+		CompactionMinFragmentation: 0.2,
 	}
 
 	db, err := createTestDB(opts)
@@ -198,7 +200,7 @@ func TestRecoveryIterator(t *testing.T) {
 
 	listRecords := func() []record {
 		var records []record
-		it := newRecoveryIterator(db.datalog.segmentsBySequenceID())
+		it := newRecoveryIterator(db.datalog.segmentsBySequenceID(), db.opts)
 		for {
 			rec, err := it.next()
 			if err == ErrIterationDone {
@@ -217,7 +219,7 @@ func TestRecoveryIterator(t *testing.T) {
 	}
 	assert.Equal(t,
 		[]record{
-			{recordTypePut, 0, 512, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}},
+			{recordTypePut, 0, 512, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}, nil},
 		},
 		listRecords(),
 	)
@@ -227,8 +229,8 @@ func TestRecoveryIterator(t *testing.T) {
 	}
 	assert.Equal(t,
 		[]record{
-			{recordTypePut, 0, 512, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}},
-			{recordTypePut, 0, 524, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}},
+			{recordTypePut, 0, 512, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}, nil},
+			{recordTypePut, 0, 524, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}, nil},
 		},
 		listRecords(),
 	)
@@ -238,12 +240,39 @@ func TestRecoveryIterator(t *testing.T) {
 	}
 	assert.Equal(t,
 		[]record{
-			{recordTypePut, 0, 512, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}},
-			{recordTypePut, 0, 524, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}},
-			{recordTypePut, 0, 536, []byte{1, 0, 1, 0, 0, 0, 2, 2, 252, 15, 236, 190}, []byte{2}, []byte{2}},
+			{recordTypePut, 0, 512, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}, nil},
+			{recordTypePut, 0, 524, []byte{1, 0, 1, 0, 0, 0, 1, 1, 133, 13, 200, 12}, []byte{1}, []byte{1}, nil},
+			{recordTypePut, 0, 536, []byte{1, 0, 1, 0, 0, 0, 2, 2, 252, 15, 236, 190}, []byte{2}, []byte{2}, nil},
 		},
 		listRecords(),
 	)
 
 	assert.Nil(t, db.Close())
 }
+
+func TestSegmentIteratorCorrupted(t *testing.T) {
+	opts := &Options{FileSystem: testFS}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+	assert.Nil(t, db.Put([]byte{2}, []byte{2}))
+	assert.Nil(t, db.Close())
+
+	// Corrupt the value of the second record.
+	f, err := testFS.OpenFile(filepath.Join(testDBName, segmentName(0, 1)), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{3}, 524+7)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	it, err := newSegmentIterator(db.datalog.segments[0])
+	assert.Nil(t, err)
+	_, err = it.next()
+	assert.Nil(t, err)
+	_, err = it.next()
+	assert.Equal(t, true, errors.Is(err, ErrCorrupted))
+	assert.Equal(t, &CorruptionError{File: segmentName(0, 1), Offset: 524, Reason: "checksum mismatch"}, err)
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,471 @@
+package pogreb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/errors"
+)
+
+const (
+	repairExt       = ".repair"
+	repairBackupExt = ".bak"
+
+	// Size of the buffer used for verifying checksums of the salvaged records.
+	salvageBufferSize = 64 << 10
+)
+
+// LostRange is a range of segment data that couldn't be salvaged by Repair.
+type LostRange struct {
+	File   string // Name of the segment file.
+	Offset int64  // Offset of the lost data in the segment file.
+	Size   int64  // Size of the lost data.
+}
+
+// RepairReport holds the result of Repair.
+type RepairReport struct {
+	Segments   int         // Number of scanned segments.
+	Records    int         // Number of salvaged records.
+	LostRanges []LostRange // Segment data that couldn't be salvaged.
+}
+
+// LostBytes returns the total size of the data that couldn't be salvaged.
+func (r RepairReport) LostBytes() int64 {
+	var n int64
+	for _, lr := range r.LostRanges {
+		n += lr.Size
+	}
+	return n
+}
+
+// Repair salvages all intact records of the DB at the specified path.
+// Unlike recovery, which truncates a corrupted segment at the first invalid record, Repair scans past corrupted data
+// looking for the next valid record. Write batches are salvaged only when all records of the batch are intact.
+// The salvaged records are written to fresh segments in a temporary directory next to the DB, the index is rebuilt
+// from scratch and the DB files are replaced once all records are salvaged.
+// The original files are moved to a backup directory next to the DB while the files are replaced. If replacing the
+// files is interrupted, the original files are left in the backup directory and Repair fails until they're restored.
+// Repairing a DB holding merge records requires Options.MergeOperator, Repair returns ErrNoMergeOperator otherwise.
+// The DB must not be open while Repair is running.
+func Repair(path string, opts *Options) (RepairReport, error) {
+	repairOpts := Options{}
+	if opts != nil {
+		repairOpts = *opts
+	}
+	if repairOpts.ReadOnly {
+		return RepairReport{}, ErrReadOnly
+	}
+	// Salvaged records are written synchronously by Repair.
+	repairOpts.BackgroundSyncInterval = 0
+	repairOpts.BackgroundCompactionInterval = 0
+	dbOpts, err := repairOpts.copyWithDefaults(path)
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	lock, _, err := createLockFile(dbOpts)
+	if err != nil {
+		if err == os.ErrExist {
+			err = ErrLocked
+		}
+		return RepairReport{}, errors.Wrap(err, "creating lock file")
+	}
+	defer lock.Unlock()
+
+	names, err := segmentNamesBySequenceID(dbOpts.FileSystem)
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	// The backup of the original files is left behind only if replacing the files was interrupted.
+	bakFiles, err := dbOpts.rootFS.ReadDir(path + repairBackupExt)
+	if err != nil && !os.IsNotExist(err) {
+		return RepairReport{}, err
+	}
+	if len(bakFiles) > 0 {
+		return RepairReport{}, fmt.Errorf("backup of the original files %s%s exists, restore it before repairing the DB", path, repairBackupExt)
+	}
+
+	// Write the salvaged records to a new DB.
+	tmpPath := path + repairExt
+	if err := removeFiles(fs.Sub(dbOpts.rootFS, tmpPath)); err != nil {
+		return RepairReport{}, err
+	}
+	tmpOpts := repairOpts
+	tmpOpts.FileSystem = dbOpts.rootFS
+	db, err := Open(tmpPath, &tmpOpts)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	s := &salvager{
+		db:  db,
+		r:   bufio.NewReader(nil),
+		buf: make([]byte, salvageBufferSize),
+	}
+	for _, name := range names {
+		if err := s.salvageSegment(dbOpts.FileSystem, name); err != nil {
+			_ = db.Close()
+			return RepairReport{}, errors.Wrapf(err, "salvaging segment %s", name)
+		}
+	}
+	if err := db.Close(); err != nil {
+		return RepairReport{}, err
+	}
+
+	if err := replaceFiles(dbOpts.rootFS, tmpPath, path); err != nil {
+		return RepairReport{}, errors.Wrap(err, "replacing DB files")
+	}
+	_ = dbOpts.rootFS.Remove(tmpPath)
+
+	return s.report, nil
+}
+
+// replaceFiles replaces the DB files in path with the repaired DB files in srcPath.
+// The original files are moved to a backup directory first and moved back if moving the repaired files fails.
+// The backup is removed only after all repaired files are in place and the DB directory is synced, if the file system
+// supports syncing directories.
+func replaceFiles(fsys fs.FileSystem, srcPath string, path string) error {
+	bakPath := path + repairBackupExt
+	if err := fsys.MkdirAll(bakPath, 0755); err != nil {
+		return err
+	}
+	files, err := fsys.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	var backedUp, replaced []string
+	restore := func() {
+		for _, name := range replaced {
+			_ = fsys.Rename(filepath.Join(path, name), filepath.Join(srcPath, name))
+		}
+		for _, name := range backedUp {
+			_ = fsys.Rename(filepath.Join(bakPath, name), filepath.Join(path, name))
+		}
+	}
+	for _, file := range files {
+		name := file.Name()
+		if name == lockName || name == rwLockName {
+			continue
+		}
+		if err := fsys.Rename(filepath.Join(path, name), filepath.Join(bakPath, name)); err != nil {
+			restore()
+			return err
+		}
+		backedUp = append(backedUp, name)
+	}
+	files, err = fsys.ReadDir(srcPath)
+	if err != nil {
+		restore()
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if err := fsys.Rename(filepath.Join(srcPath, name), filepath.Join(path, name)); err != nil {
+			restore()
+			return err
+		}
+		replaced = append(replaced, name)
+	}
+	if ds, ok := fsys.(fs.DirSyncer); ok {
+		if err := ds.SyncDir(path); err != nil {
+			restore()
+			return err
+		}
+	}
+	if err := removeFiles(fs.Sub(fsys, bakPath)); err != nil {
+		return err
+	}
+	_ = fsys.Remove(bakPath)
+	return nil
+}
+
+// segmentNamesBySequenceID returns the names of the segment files ordered from oldest to newest.
+func segmentNamesBySequenceID(fsys fs.FileSystem) ([]string, error) {
+	files, err := fsys.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seqIDs := make(map[string]uint64)
+	for _, file := range files {
+		name := file.Name()
+		if filepath.Ext(name) != segmentExt {
+			continue
+		}
+		_, seqID, err := parseSegmentName(name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		seqIDs[name] = seqID
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return seqIDs[names[i]] < seqIDs[names[j]]
+	})
+	return names, nil
+}
+
+// removeFiles removes all files in the directory.
+func removeFiles(fsys fs.FileSystem) error {
+	files, err := fsys.ReadDir(".")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, file := range files {
+		if err := fsys.Remove(file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// salvager writes intact records of corrupted segments to a DB.
+type salvager struct {
+	db      *DB
+	report  RepairReport
+	seg     *segment         // Segment being salvaged.
+	it      *segmentIterator // Iterator reading the intact records of the segment.
+	r       *bufio.Reader    // Reader reused by the iterator.
+	buf     []byte           // Buffer reused for verifying record checksums.
+	lostOff int64            // Offset of the lost segment data, -1 if all preceding data was salvaged.
+}
+
+// salvageSegment writes all intact records of the segment to the DB.
+func (s *salvager) salvageSegment(fsys fs.FileSystem, name string) error {
+	id, seqID, err := parseSegmentName(name)
+	if err != nil {
+		return err
+	}
+	f, err := fsys.OpenFile(name, os.O_RDONLY, os.FileMode(0640))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	s.seg = &segment{
+		file:       &file{File: f, size: stat.Size()},
+		id:         id,
+		sequenceID: seqID,
+		name:       name,
+	}
+	s.it = &segmentIterator{
+		f:   s.seg,
+		r:   s.r,
+		buf: make([]byte, 6),
+	}
+	s.lostOff = -1
+	s.report.Segments++
+
+	// Corrupted headers don't affect the records.
+	off := int64(headerSize)
+	for off < s.seg.size {
+		end, err := s.salvageRecords(off)
+		if err != nil {
+			return err
+		}
+		if end == off {
+			// No valid records at the offset, look for the next valid record.
+			s.markLost(off)
+			off++
+			continue
+		}
+		off = end
+	}
+	s.markSalvaged(s.seg.size)
+	return nil
+}
+
+// markLost marks the segment data starting at the given offset as lost.
+func (s *salvager) markLost(off int64) {
+	if s.lostOff == -1 {
+		s.lostOff = off
+	}
+}
+
+// markSalvaged marks the segment data starting at the given offset as salvaged, ending the lost data range.
+func (s *salvager) markSalvaged(off int64) {
+	if s.lostOff == -1 {
+		return
+	}
+	s.report.LostRanges = append(s.report.LostRanges, LostRange{
+		File:   s.seg.name,
+		Offset: s.lostOff,
+		Size:   off - s.lostOff,
+	})
+	s.lostOff = -1
+}
+
+// salvageRecords writes the consecutive intact records starting at the given offset to the DB.
+// It returns the offset following the last read record.
+func (s *salvager) salvageRecords(off int64) (int64, error) {
+	size := s.recordSize(off)
+	if size == 0 {
+		return off, nil
+	}
+	it := s.it
+	it.offset = uint32(off)
+	it.r.Reset(io.NewSectionReader(s.seg, off, s.seg.size-off))
+	for ; size != 0; size = s.recordSize(off) {
+		rec, err := it.next()
+		if err != nil {
+			return 0, err
+		}
+		if rec.rtype == recordTypeBatch {
+			n, complete, err := s.salvageBatch(it, rec)
+			if err != nil {
+				return 0, err
+			}
+			if !complete {
+				// All records of an incomplete batch are lost.
+				s.markLost(off)
+				return off + n, nil
+			}
+			s.markSalvaged(off)
+			off += n
+			continue
+		}
+		s.markSalvaged(off)
+		if err := s.apply(rec); err != nil {
+			return 0, err
+		}
+		off += int64(len(rec.data))
+	}
+	return off, nil
+}
+
+// recordSize returns the size of the intact record at the given offset or 0 if there is no intact record at the offset.
+// Sizes of corrupted records are arbitrary, the checksum is verified reading the record in chunks of the salvager
+// buffer size before the record is read by the iterator.
+func (s *salvager) recordSize(off int64) int64 {
+	seg := s.seg
+	buf := s.buf[:6]
+	if off+6 > seg.size {
+		return 0
+	}
+	if _, err := seg.ReadAt(buf, off); err != nil {
+		return 0
+	}
+	keySize := uint32(binary.LittleEndian.Uint16(buf[:2]))
+	valueSize := binary.LittleEndian.Uint32(buf[2:])
+	extended := valueSize&extendedBit != 0
+	if extended && valueSize&deleteBit != 0 {
+		return 0
+	}
+	valueSize &^= deleteBit | extendedBit
+	if valueSize > MaxValueLength {
+		return 0
+	}
+	size := int64(encodedRecordSize(keySize + valueSize))
+	if extended {
+		// Read the record type to find out the size of the type-specific data.
+		rtOff := off + 6 + int64(keySize) + int64(valueSize)
+		if rtOff >= seg.size {
+			return 0
+		}
+		if _, err := seg.ReadAt(buf[:1], rtOff); err != nil {
+			return 0
+		}
+		extraSize, ok := recordType(buf[0]).extraSize()
+		if !ok {
+			return 0
+		}
+		size += 1 + int64(extraSize)
+	}
+	if off+size > seg.size {
+		return 0
+	}
+
+	var checksum uint32
+	end := off + size - 4
+	for pos := off; pos < end; {
+		chunk := s.buf[:min(int64(len(s.buf)), end-pos)]
+		if _, err := seg.ReadAt(chunk, pos); err != nil {
+			return 0
+		}
+		checksum = crc32.Update(checksum, crc32.IEEETable, chunk)
+		pos += int64(len(chunk))
+	}
+	if _, err := seg.ReadAt(buf[:4], end); err != nil {
+		return 0
+	}
+	if checksum != binary.LittleEndian.Uint32(buf[:4]) {
+		return 0
+	}
+	return size
+}
+
+// salvageBatch reads the records of the batch and writes the batch to the DB if all batch records are valid.
+// It returns the size of the valid batch records, including the batch record, and whether the batch is complete.
+func (s *salvager) salvageBatch(it *segmentIterator, rec record) (int64, bool, error) {
+	n := int64(len(rec.data))
+	b := NewWriteBatch()
+	for i := uint32(0); i < rec.batchSize(); i++ {
+		if s.recordSize(int64(it.offset)) == 0 {
+			return n, false, nil
+		}
+		brec, err := it.next()
+		if err != nil {
+			return 0, false, err
+		}
+		switch brec.rtype {
+		case recordTypePut:
+			err = b.Put(brec.key, brec.value)
+		case recordTypeDelete:
+			err = b.Delete(brec.key)
+		default:
+			// The record doesn't belong to the batch.
+			return n, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		n += int64(len(brec.data))
+	}
+	if err := s.db.Write(b); err != nil {
+		return 0, false, err
+	}
+	s.report.Records += int(rec.batchSize())
+	return n, true, nil
+}
+
+// apply writes the salvaged record to the DB.
+func (s *salvager) apply(rec record) error {
+	var err error
+	switch {
+	case rec.rtype == recordTypeDelete:
+		err = s.db.Delete(rec.key)
+	case rec.expired(s.db.now()):
+		// The record hides older values of the key.
+		err = s.db.Delete(rec.key)
+	case rec.rtype == recordTypeMerge:
+		if s.db.opts.MergeOperator == nil {
+			// Merge chains are folded by the merge operator when salvaged merge records don't fit in a segment.
+			return errors.Wrapf(ErrNoMergeOperator, "salvaging merge record at offset %d", rec.offset)
+		}
+		err = s.db.Merge(rec.key, rec.value)
+	case rec.rtype == recordTypePutTTL:
+		err = s.db.putValue(rec.key, rec.value, rec.expiresAt())
+	case rec.rtype == recordTypePut:
+		err = s.db.Put(rec.key, rec.value)
+	default:
+		err = fmt.Errorf("unexpected record type %d", rec.rtype)
+	}
+	if err != nil {
+		return err
+	}
+	s.report.Records++
+	return nil
+}
@@ -0,0 +1,230 @@
+package pogreb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/fs"
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func corruptFile(t *testing.T, name string, off int64) {
+	t.Helper()
+	f, err := testFS.OpenFile(name, os.O_RDWR, os.FileMode(0640))
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff}, off)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+func TestRepair(t *testing.T) {
+	clock := newTestClock()
+	opts := &Options{
+		MergeOperator:  Int64AddOperator,
+		MaxSegmentSize: 1024,
+		now:            clock.now,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	b := NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{10}, []byte{10}))
+	assert.Nil(t, b.Put([]byte{11}, []byte{11}))
+	assert.Nil(t, db.Write(b))
+	b = NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{12}, []byte{12}))
+	assert.Nil(t, b.Delete([]byte{1}))
+	assert.Nil(t, db.Write(b))
+	assert.Nil(t, db.PutWithTTL([]byte{13}, []byte{13}, time.Hour))
+	assert.Nil(t, db.Merge([]byte{14}, int64Bytes(1)))
+	assert.Nil(t, db.Merge([]byte{14}, int64Bytes(2)))
+	for i := 15; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	assert.Equal(t, true, countSegments(t, db) > 1)
+
+	// Repair fails while the DB is open.
+	_, err = Repair(testDBName, opts)
+	assert.Equal(t, true, errors.Is(err, ErrLocked))
+
+	items := dbItems(t, db)
+	segments := countSegments(t, db)
+	assert.Nil(t, db.Close())
+
+	// Corrupt the value of the record with key 5.
+	segName := filepath.Join(testDBName, segmentName(0, 1))
+	corruptFile(t, segName, int64(headerSize)+5*12+7)
+	delete(items, string([]byte{5}))
+	// Corrupt the second record of the first batch.
+	batchOff := int64(headerSize) + 10*12
+	corruptFile(t, segName, batchOff+15+12+7)
+	delete(items, string([]byte{10}))
+	delete(items, string([]byte{11}))
+
+	// Merge records can't be salvaged without the merge operator, the DB files are left intact.
+	files := readFiles(t, testDBName)
+	_, err = Repair(testDBName, &Options{FileSystem: testFS, MaxSegmentSize: 1024, now: clock.now})
+	assert.Equal(t, true, errors.Is(err, ErrNoMergeOperator))
+	assert.Equal(t, files, readFiles(t, testDBName))
+
+	report, err := Repair(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []LostRange{
+		{File: segmentName(0, 1), Offset: int64(headerSize) + 5*12, Size: 12},
+		{File: segmentName(0, 1), Offset: batchOff, Size: 15 + 12 + 12},
+	}, report.LostRanges)
+	assert.Equal(t, int64(12+39), report.LostBytes())
+	assert.Equal(t, segments, report.Segments)
+	assert.Equal(t, 102-3, report.Records)
+
+	// The repaired DB opens without recovery.
+	db, err = Open(testDBName, &Options{FileSystem: testFS, MergeOperator: Int64AddOperator, ReadOnly: true, now: clock.now})
+	assert.Nil(t, err)
+	assert.Equal(t, items, dbItems(t, db))
+	v, err := db.Get([]byte{14})
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(3), v)
+	report2, err := db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, true, report2.OK())
+	assert.Nil(t, db.Close())
+
+	// Repairing an intact DB doesn't lose data.
+	report, err = Repair(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.LostRanges))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, items, dbItems(t, db))
+	assert.Nil(t, db.Close())
+}
+
+// failRenameFS fails renaming files from one directory to another after the given number of renames.
+type failRenameFS struct {
+	fs.FileSystem
+	from    string
+	to      string
+	renames int // Number of renames to allow before failing, -1 allows all renames.
+}
+
+var errRenameFailed = errors.New("rename failed")
+
+func (f *failRenameFS) Rename(oldpath, newpath string) error {
+	if f.renames != -1 && filepath.Dir(oldpath) == f.from && filepath.Dir(newpath) == f.to {
+		if f.renames == 0 {
+			return errRenameFailed
+		}
+		f.renames--
+	}
+	return f.FileSystem.Rename(oldpath, newpath)
+}
+
+// readFiles returns the contents of the files in the directory, excluding the lock files.
+// It returns no files if the directory doesn't exist.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	contents := make(map[string]string)
+	files, err := testFS.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return contents
+	}
+	assert.Nil(t, err)
+	for _, file := range files {
+		if file.Name() == lockName || file.Name() == rwLockName {
+			continue
+		}
+		f, err := testFS.OpenFile(filepath.Join(dir, file.Name()), os.O_RDONLY, 0)
+		assert.Nil(t, err)
+		data, err := io.ReadAll(f)
+		assert.Nil(t, err)
+		assert.Nil(t, f.Close())
+		contents[file.Name()] = string(data)
+	}
+	return contents
+}
+
+func TestRepairRenameError(t *testing.T) {
+	fsys := &failRenameFS{FileSystem: testFS, from: testDBName + repairExt, to: testDBName, renames: -1}
+	opts := &Options{FileSystem: fsys, MaxSegmentSize: 1024}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	items := dbItems(t, db)
+	assert.Nil(t, db.Close())
+	corruptFile(t, filepath.Join(testDBName, segmentName(0, 1)), int64(headerSize)+7)
+	delete(items, string([]byte{0}))
+	files := readFiles(t, testDBName)
+
+	// Moving the repaired files fails after some of them are moved, the original files are restored.
+	fsys.renames = 1
+	_, err = Repair(testDBName, opts)
+	assert.Equal(t, true, errors.Is(err, errRenameFailed))
+	assert.Equal(t, files, readFiles(t, testDBName))
+	assert.Equal(t, 0, len(readFiles(t, testDBName+repairBackupExt)))
+
+	fsys.renames = -1
+	_, err = Repair(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(readFiles(t, testDBName+repairBackupExt)))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, items, dbItems(t, db))
+	assert.Nil(t, db.Close())
+
+	// Repair fails if the backup of the original files is left behind by an interrupted Repair.
+	assert.Nil(t, testFS.MkdirAll(testDBName+repairBackupExt, 0755))
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName+repairBackupExt, segmentName(0, 1))))
+	_, err = Repair(testDBName, opts)
+	assert.NotNil(t, err)
+	assert.Nil(t, testFS.Remove(filepath.Join(testDBName+repairBackupExt, segmentName(0, 1))))
+}
+
+func TestRepairCorruptedRecordSize(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	items := dbItems(t, db)
+	assert.Nil(t, db.Close())
+
+	// The corrupted value size of the first record covers the following records.
+	segName := filepath.Join(testDBName, segmentName(0, 1))
+	f, err := testFS.OpenFile(segName, os.O_RDWR, os.FileMode(0640))
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{100}, int64(headerSize)+2)
+	assert.Nil(t, err)
+	stat, err := f.Stat()
+	assert.Nil(t, err)
+	s := &salvager{
+		seg: &segment{file: &file{File: f, size: stat.Size()}},
+		buf: make([]byte, 16),
+	}
+	assert.Equal(t, int64(0), s.recordSize(int64(headerSize)))
+	assert.Equal(t, int64(0), s.recordSize(int64(headerSize)+1))
+	assert.Equal(t, int64(12), s.recordSize(int64(headerSize)+12))
+	// Corrupted records aren't read into memory.
+	assert.Equal(t, float64(0), testing.AllocsPerRun(10, func() {
+		s.recordSize(int64(headerSize))
+	}))
+	assert.Nil(t, f.Close())
+
+	opts := &Options{FileSystem: testFS}
+	report, err := Repair(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []LostRange{{File: segmentName(0, 1), Offset: int64(headerSize), Size: 12}}, report.LostRanges)
+	delete(items, string([]byte{0}))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, items, dbItems(t, db))
+	assert.Nil(t, db.Close())
+}
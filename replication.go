@@ -0,0 +1,410 @@
+package pogreb
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Replication protocol.
+//
+// The follower starts by sending the list of its segments: the number of segments (4B) followed by the physical
+// identifier (2B), the sequence identifier (8B) and the size (8B) of each segment.
+// The leader replies with a stream of frames, each frame starts with the frame type (1B):
+//
+//	Segment: | Segment ID (2B) | Sequence ID (8B) |
+//	Records: | Segment ID (2B) | Offset (4B) | Size (4B) | Records |
+//	Remove:  | Segment ID (2B) | Sequence ID (8B) |
+//
+// The segment frame makes the segment current on the follower, the records frame appends encoded records to the
+// segment at the given offset and the remove frame removes the segment removed by the leader compaction.
+// Replicated segments are byte-for-byte copies of the leader segments.
+const (
+	replFrameSegment byte = iota + 1
+	replFrameRecords
+	replFrameRemove
+
+	// replMaxFrameSize is the approximate maximum size of records sent in a single records frame.
+	// Larger records and batches are sent in a single frame.
+	replMaxFrameSize = 1 << 20
+)
+
+// replSegment is the state of a segment replicated to the follower.
+type replSegment struct {
+	id     uint16
+	offset int64 // Offset of the next record to send.
+}
+
+// replState is the state of the follower as seen by the leader.
+type replState struct {
+	segments map[uint64]*replSegment // Follower segments by sequence ID.
+	current  uint64                  // Sequence ID of the current follower segment, 0 if unknown.
+}
+
+// Replicate streams the write-ahead log to the follower connected through conn.
+// The follower must be running DB.Follow on the other end of conn.
+// Replicate sends the records the follower is missing and then keeps sending new records as they are written.
+// It returns when ctx is done, the DB is closed or writing to conn fails.
+func (db *DB) Replicate(ctx context.Context, conn io.ReadWriter) error {
+	segments, err := readReplicaSegments(conn)
+	if err != nil {
+		return err
+	}
+	state := &replState{segments: segments}
+	w := bufio.NewWriter(conn)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		frames, wait, err := db.replicationFrames(state)
+		if err != nil {
+			return err
+		}
+		if len(frames) > 0 {
+			if _, err := w.Write(frames); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+func readReplicaSegments(r io.Reader) (map[uint64]*replSegment, error) {
+	buf := make([]byte, 18)
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(buf[:4])
+	if n > maxSegments {
+		return nil, fmt.Errorf("invalid number of replica segments %d", n)
+	}
+	segments := make(map[uint64]*replSegment, n)
+	for i := uint32(0); i < n; i++ {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		seqID := binary.LittleEndian.Uint64(buf[2:10])
+		segments[seqID] = &replSegment{
+			id:     binary.LittleEndian.Uint16(buf[:2]),
+			offset: int64(binary.LittleEndian.Uint64(buf[10:18])),
+		}
+	}
+	return segments, nil
+}
+
+// replicationFrames returns the frames replicating the changes the follower doesn't have yet or the channel to wait on
+// if the follower is up-to-date.
+func (db *DB) replicationFrames(state *replState) ([]byte, <-chan struct{}, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	dl := db.datalog
+	if dl.changes.closed {
+		return nil, nil, ErrClosed
+	}
+	var frames []byte
+	live := make(map[uint64]bool)
+	for _, seg := range dl.segmentsBySequenceID() {
+		if seg.compacted {
+			// The segment will be removed once it's released.
+			continue
+		}
+		live[seg.sequenceID] = true
+		rs := state.segments[seg.sequenceID]
+		if rs == nil {
+			rs = &replSegment{id: seg.id, offset: int64(headerSize)}
+			state.segments[seg.sequenceID] = rs
+		}
+		if rs.offset > seg.size {
+			return nil, nil, fmt.Errorf("replica segment %s is larger than the leader segment", seg.name)
+		}
+		if rs.offset == seg.size {
+			continue
+		}
+		if len(frames) >= replMaxFrameSize {
+			return frames, nil, nil
+		}
+		end, err := dl.recordsEnd(seg, rs.offset, int64(replMaxFrameSize-len(frames)))
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := seg.Slice(rs.offset, end)
+		if err != nil {
+			return nil, nil, err
+		}
+		if state.current != seg.sequenceID {
+			// Make the segment current on the follower before appending records to it.
+			frames = appendReplSegmentFrame(frames, replFrameSegment, seg.id, seg.sequenceID)
+			state.current = seg.sequenceID
+		}
+		var head [11]byte
+		head[0] = replFrameRecords
+		binary.LittleEndian.PutUint16(head[1:3], seg.id)
+		binary.LittleEndian.PutUint32(head[3:7], uint32(rs.offset))
+		binary.LittleEndian.PutUint32(head[7:11], uint32(len(data)))
+		frames = append(frames, head[:]...)
+		frames = append(frames, data...)
+		rs.offset = end
+	}
+	if len(frames) > 0 {
+		return frames, nil, nil
+	}
+	// Remove segments removed by compaction after the records copied by compaction are replicated.
+	for seqID, rs := range state.segments {
+		if live[seqID] {
+			continue
+		}
+		frames = appendReplSegmentFrame(frames, replFrameRemove, rs.id, seqID)
+		delete(state.segments, seqID)
+		if state.current == seqID {
+			// Removing the current segment makes the newest segment current on the follower.
+			state.current = 0
+		}
+	}
+	if len(frames) > 0 {
+		return frames, nil, nil
+	}
+	return nil, dl.changes.wait(), nil
+}
+
+func appendReplSegmentFrame(frames []byte, frameType byte, id uint16, seqID uint64) []byte {
+	var buf [11]byte
+	buf[0] = frameType
+	binary.LittleEndian.PutUint16(buf[1:3], id)
+	binary.LittleEndian.PutUint64(buf[3:11], seqID)
+	return append(frames, buf[:]...)
+}
+
+// Follow replicates the leader DB running DB.Replicate on the other end of conn.
+// The follower segments become byte-for-byte copies of the leader segments, the replicated records are applied to
+// the index the same way as during recovery.
+// The follower must not be modified by other means: while Follow is running, compaction returns ErrBusy and local
+// writes return ErrReadOnly.
+// Follow returns nil when the leader closes the connection, it returns the context error when ctx is done.
+// A blocked read from conn isn't interrupted by ctx, close conn to stop Follow.
+func (db *DB) Follow(ctx context.Context, conn io.ReadWriter) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	// Compaction modifies segments, it must not run on the follower.
+	if !db.maintenanceMu.TryLock() {
+		return ErrBusy
+	}
+	defer db.maintenanceMu.Unlock()
+
+	db.mu.Lock()
+	db.following = true
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.following = false
+		db.mu.Unlock()
+	}()
+
+	if err := db.writeReplicaSegments(conn); err != nil {
+		return err
+	}
+	r := bufio.NewReader(conn)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := db.applyReplicationFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (db *DB) writeReplicaSegments(w io.Writer) error {
+	db.mu.RLock()
+	segments := db.datalog.segmentsBySequenceID()
+	buf := make([]byte, 4, 4+18*len(segments))
+	binary.LittleEndian.PutUint32(buf, uint32(len(segments)))
+	for _, seg := range segments {
+		var entry [18]byte
+		binary.LittleEndian.PutUint16(entry[:2], seg.id)
+		binary.LittleEndian.PutUint64(entry[2:10], seg.sequenceID)
+		binary.LittleEndian.PutUint64(entry[10:18], uint64(seg.size))
+		buf = append(buf, entry[:]...)
+	}
+	db.mu.RUnlock()
+	_, err := w.Write(buf)
+	return err
+}
+
+// applyReplicationFrame reads a single frame and applies it.
+// It returns io.EOF if the stream ended before the frame.
+func (db *DB) applyReplicationFrame(r io.Reader) error {
+	var head [11]byte
+	if _, err := io.ReadFull(r, head[:1]); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, head[1:]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	id := binary.LittleEndian.Uint16(head[1:3])
+	switch head[0] {
+	case replFrameSegment:
+		return db.openReplicaSegment(id, binary.LittleEndian.Uint64(head[3:11]))
+	case replFrameRemove:
+		return db.removeReplicaSegment(id, binary.LittleEndian.Uint64(head[3:11]))
+	case replFrameRecords:
+		offset := binary.LittleEndian.Uint32(head[3:7])
+		data := make([]byte, binary.LittleEndian.Uint32(head[7:11]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		return db.appendReplicaRecords(id, offset, data)
+	}
+	return fmt.Errorf("unknown replication frame type %d", head[0])
+}
+
+func (db *DB) openReplicaSegment(id uint16, seqID uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if seg := db.datalog.segments[id]; seg != nil && seg.sequenceID != seqID {
+		// The leader reused the identifier of a segment it removed.
+		if seg.refs > 0 {
+			return fmt.Errorf("replicating segment %s: segment %s is in use", segmentName(id, seqID), seg.name)
+		}
+		if err := db.dropReplicaSegment(seg); err != nil {
+			return err
+		}
+	}
+	return db.datalog.openReplicaSegment(id, seqID)
+}
+
+func (db *DB) removeReplicaSegment(id uint16, seqID uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	seg := db.datalog.segments[id]
+	if seg == nil || seg.sequenceID != seqID || seg.compacted {
+		return nil
+	}
+	return db.dropReplicaSegment(seg)
+}
+
+// dropReplicaSegment removes the segment removed by the leader.
+// The leader compaction deletes expired keys from the index without writing delete records, the keys still pointing
+// to the segment are deleted from the index.
+func (db *DB) dropReplicaSegment(seg *segment) error {
+	it, err := newSegmentIterator(seg)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := it.next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if rec.rtype == recordTypeDelete || rec.rtype == recordTypeBatch {
+			continue
+		}
+		b, i, found, err := db.findRecordSlot(rec)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		db.trackSnapshots(rec.key, b.slots[i], true)
+		b.del(i)
+		db.index.numKeys--
+		if err := b.write(); err != nil {
+			return err
+		}
+	}
+	return db.datalog.removeReplicaSegment(seg)
+}
+
+// appendReplicaRecords appends the encoded records to the segment and applies them to the index.
+func (db *DB) appendReplicaRecords(id uint16, offset uint32, data []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	seg := db.datalog.segments[id]
+	if seg == nil || seg != db.datalog.curSeg || seg.size != int64(offset) {
+		return fmt.Errorf("replicated records at offset %d of segment %d don't match the replica", offset, id)
+	}
+
+	// Verify all records before modifying the segment.
+	it := &segmentIterator{
+		f:      seg,
+		offset: offset,
+		r:      bufio.NewReader(bytes.NewReader(data)),
+		buf:    make([]byte, 6),
+	}
+	var records []record
+	for {
+		rec, err := it.next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return it.corrupted("truncated record")
+		}
+		if err != nil {
+			return err
+		}
+		records = append(records, rec)
+	}
+
+	for _, rec := range records {
+		if _, extended := rec.rtype.extraSize(); extended {
+			if err := seg.upgradeHeader(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if _, err := seg.append(data); err != nil {
+		return err
+	}
+	now := db.now()
+	for _, rec := range records {
+		if err := db.applyRecord(rec, now); err != nil {
+			return err
+		}
+	}
+	db.datalog.changes.notify()
+
+	if db.syncWrites {
+		return db.sync()
+	}
+	return nil
+}
+
+// ReplicationPosition returns the position of the end of the replicated write-ahead log.
+// For the follower it's the position in the leader write-ahead log the follower has replicated up to.
+func (db *DB) ReplicationPosition() ChangePosition {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	segments := db.datalog.segmentsBySequenceID()
+	if len(segments) == 0 {
+		return ChangePosition{}
+	}
+	seg := segments[len(segments)-1]
+	return ChangePosition{SequenceID: seg.sequenceID, Offset: uint32(seg.size)}
+}
@@ -0,0 +1,193 @@
+package pogreb
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+const testDBFollowerName = testDBName + ".follower"
+
+func dbItems(t *testing.T, db *DB) map[string]string {
+	t.Helper()
+	items := make(map[string]string)
+	it := db.Items()
+	for {
+		key, value, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		items[string(key)] = string(value)
+	}
+	return items
+}
+
+// waitReplicated waits until the follower holds the same items as the leader.
+func waitReplicated(t *testing.T, leader *DB, follower *DB) {
+	t.Helper()
+	expected := dbItems(t, leader)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		items := dbItems(t, follower)
+		if len(items) == len(expected) && follower.Count() == leader.Count() {
+			equal := true
+			for k, v := range expected {
+				if items[k] != v {
+					equal = false
+					break
+				}
+			}
+			if equal {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("follower isn't replicated: expected %d items; got %d", len(expected), len(items))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func startReplication(leader *DB, follower *DB) (stop func() (error, error)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lconn, fconn := net.Pipe()
+	leaderErrC := make(chan error, 1)
+	followerErrC := make(chan error, 1)
+	go func() {
+		leaderErrC <- leader.Replicate(ctx, lconn)
+	}()
+	go func() {
+		followerErrC <- follower.Follow(ctx, fconn)
+	}()
+	return func() (error, error) {
+		cancel()
+		leaderErr := <-leaderErrC
+		_ = lconn.Close()
+		followerErr := <-followerErrC
+		_ = fconn.Close()
+		return leaderErr, followerErr
+	}
+}
+
+func TestReplication(t *testing.T) {
+	opts := &Options{
+		MergeOperator:              Int64AddOperator,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	leader, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, cleanDir(testDBFollowerName))
+	followerOpts := &Options{FileSystem: testFS, MergeOperator: Int64AddOperator}
+	follower, err := Open(testDBFollowerName, followerOpts)
+	assert.Nil(t, err)
+
+	// Records written before the follower connected.
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+
+	stop := startReplication(leader, follower)
+	waitReplicated(t, leader, follower)
+
+	// Live records.
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Put([]byte{byte(i)}, []byte{byte(i), byte(i)}))
+	}
+	assert.Nil(t, leader.Delete([]byte{0}))
+	b := NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{100}, []byte{100}))
+	assert.Nil(t, b.Delete([]byte{1}))
+	assert.Nil(t, leader.Write(b))
+	assert.Nil(t, leader.Merge([]byte{101}, int64Bytes(1)))
+	assert.Nil(t, leader.Merge([]byte{101}, int64Bytes(2)))
+	waitReplicated(t, leader, follower)
+	assert.Equal(t, true, countSegments(t, follower) > 1)
+
+	// Segments removed by compaction are removed from the follower.
+	cr, err := leader.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, true, cr.CompactedSegments > 0)
+	assert.Nil(t, leader.Put([]byte{102}, []byte{102}))
+	waitReplicated(t, leader, follower)
+	leaderErr, followerErr := stop()
+	assert.Equal(t, context.Canceled, leaderErr)
+	assert.Nil(t, followerErr)
+
+	leaderSegments := make(map[string]bool)
+	for _, seg := range leader.datalog.segmentsBySequenceID() {
+		leaderSegments[seg.name] = true
+	}
+	for _, seg := range follower.datalog.segmentsBySequenceID() {
+		assert.Equal(t, true, leaderSegments[seg.name])
+	}
+	assert.Equal(t, leader.ReplicationPosition(), follower.ReplicationPosition())
+
+	// The follower resumes from its replicated position after reopening.
+	assert.Nil(t, follower.Close())
+	follower, err = Open(testDBFollowerName, followerOpts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, leader.Merge([]byte{101}, int64Bytes(1)))
+	}
+	stop = startReplication(leader, follower)
+	waitReplicated(t, leader, follower)
+	_, followerErr = stop()
+	assert.Nil(t, followerErr)
+	v, err := follower.Get([]byte{101})
+	assert.Nil(t, err)
+	assert.Equal(t, int64Bytes(13), v)
+
+	// The follower is a regular DB.
+	assert.Nil(t, follower.Close())
+	follower, err = Open(testDBFollowerName, followerOpts)
+	assert.Nil(t, err)
+	waitReplicated(t, leader, follower)
+	assert.Nil(t, follower.Put([]byte{103}, []byte{103}))
+
+	assert.Nil(t, follower.Close())
+	assert.Nil(t, leader.Close())
+}
+
+func TestFollowLocalWrites(t *testing.T) {
+	opts := &Options{MergeOperator: Int64AddOperator}
+	leader, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, cleanDir(testDBFollowerName))
+	followerOpts := &Options{FileSystem: testFS, MergeOperator: Int64AddOperator}
+	follower, err := Open(testDBFollowerName, followerOpts)
+	assert.Nil(t, err)
+	assert.Nil(t, leader.Put([]byte{1}, []byte{1}))
+
+	stop := startReplication(leader, follower)
+	waitReplicated(t, leader, follower)
+
+	// Local writes are rejected while Follow is running.
+	assert.Equal(t, ErrReadOnly, follower.Put([]byte{2}, []byte{2}))
+	assert.Equal(t, ErrReadOnly, follower.Delete([]byte{1}))
+	b := NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{2}, []byte{2}))
+	assert.Equal(t, ErrReadOnly, follower.Write(b))
+	_, err = follower.PutIfAbsent([]byte{2}, []byte{2})
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, follower.Merge([]byte{2}, int64Bytes(1)))
+	assert.Equal(t, ErrReadOnly, follower.PutReader([]byte{2}, bytes.NewReader([]byte{2}), 1))
+
+	assert.Nil(t, leader.Put([]byte{3}, []byte{3}))
+	waitReplicated(t, leader, follower)
+	_, followerErr := stop()
+	assert.Nil(t, followerErr)
+
+	// Local writes are allowed after Follow returns.
+	assert.Nil(t, follower.Put([]byte{2}, []byte{2}))
+	assert.Equal(t, uint32(3), follower.Count())
+	assert.Nil(t, follower.Close())
+	assert.Nil(t, leader.Close())
+	assert.Nil(t, cleanDir(testDBFollowerName))
+}
@@ -13,10 +13,40 @@ type recordType int
 const (
 	recordTypePut recordType = iota
 	recordTypeDelete
+	recordTypeBatch
+	recordTypePutTTL
+	recordTypeMerge
 
 	segmentExt = ".psg"
 )
 
+const (
+	deleteBit   = 1 << 31 // Set in the value size field of delete records.
+	extendedBit = 1 << 30 // Set in the value size field of extended records.
+)
+
+// extraSize returns the size of the type-specific data stored in extended records.
+func (rt recordType) extraSize() (uint32, bool) {
+	switch rt {
+	case recordTypeBatch:
+		return 4, true // Number of records in the batch.
+	case recordTypePutTTL:
+		return 8, true // Expiration time.
+	case recordTypeMerge:
+		return 16, true // Offset and segment ID of the previous record, chain depth and expiration time.
+	}
+	return 0, false
+}
+
+// recordSize returns the size of an encoded record of the given type.
+func recordSize(kvSize uint32, rt recordType) uint32 {
+	size := encodedRecordSize(kvSize)
+	if extraSize, ok := rt.extraSize(); ok {
+		size += 1 + extraSize
+	}
+	return size
+}
+
 // segment is a write-ahead log segment.
 // It consists of a sequence of binary-encoded variable length records.
 type segment struct {
@@ -25,6 +55,8 @@ type segment struct {
 	sequenceID uint64 // Logical monotonically increasing segment identifier.
 	name       string
 	meta       *segmentMeta
+	refs       int  // Number of references from open snapshots.
+	compacted  bool // The segment is compacted, but can't be removed until it's referenced.
 }
 
 func segmentName(id uint16, sequenceID uint64) string {
@@ -32,11 +64,49 @@ func segmentName(id uint16, sequenceID uint64) string {
 }
 
 type segmentMeta struct {
-	Full          bool
-	PutRecords    uint32
-	DeleteRecords uint32
-	DeletedKeys   uint32
-	DeletedBytes  uint32
+	Full            bool
+	PutRecords      uint32
+	DeleteRecords   uint32
+	MergeRecords    uint32
+	DeletedKeys     uint32
+	DeletedBytes    uint32
+	ExpiringRecords uint32 // Number of put records with expiration time.
+	ExpiringBytes   uint32 // Size of put records with expiration time, excluding deleted records.
+	MinExpiresAt    int64  // Earliest expiration time of put records.
+	MaxExpiresAt    int64  // Latest expiration time of put records.
+}
+
+// trackExpiring updates the metadata for a put record with expiration time.
+func (meta *segmentMeta) trackExpiring(size uint32, expiresAt int64) {
+	if meta.ExpiringRecords == 0 || expiresAt < meta.MinExpiresAt {
+		meta.MinExpiresAt = expiresAt
+	}
+	if expiresAt > meta.MaxExpiresAt {
+		meta.MaxExpiresAt = expiresAt
+	}
+	meta.ExpiringRecords++
+	meta.ExpiringBytes += size
+}
+
+// expiredBytes returns the size of records expired at the given time.
+// Expiration time of individual records is not tracked, the records are considered expired only when all put records
+// with expiration time are expired.
+func (meta *segmentMeta) expiredBytes(now int64) uint32 {
+	if meta.ExpiringRecords == 0 || meta.MaxExpiresAt > now {
+		return 0
+	}
+	return meta.ExpiringBytes
+}
+
+// fragmentation returns the ratio of the deleted and expired records size to the segment size at the given time.
+func (seg *segment) fragmentation(now int64) float32 {
+	return float32(seg.meta.DeletedBytes+seg.meta.expiredBytes(now)) / float32(seg.size)
+}
+
+// hasTombstones returns true if the segment contains records hiding older put records for the same keys:
+// delete records or put records expired at the given time.
+func (meta *segmentMeta) hasTombstones(now int64) bool {
+	return meta.DeleteRecords > 0 || (meta.ExpiringRecords > 0 && meta.MinExpiresAt <= now)
 }
 
 func segmentMetaName(id uint16, sequenceID uint64) string {
@@ -47,6 +117,12 @@ func segmentMetaName(id uint16, sequenceID uint64) string {
 // +---------------+------------------+------------------+-...-+--...--+----------+
 // | Key Size (2B) | Record Type (1b) | Value Size (31b) | Key | Value | CRC (4B) |
 // +---------------+------------------+------------------+-...-+--...--+----------+
+//
+// Binary representation of an extended segment record:
+// +---------------+-----------------+---------------+------------------+-...-+--...--+------------------+-...-+----------+
+// | Key Size (2B) | Delete Bit (1b) | Ext. Bit (1b) | Value Size (30b) | Key | Value | Record Type (1B) | ... | CRC (4B) |
+// +---------------+-----------------+---------------+------------------+-...-+--...--+------------------+-...-+----------+
+// The size of the type-specific data following the record type is defined by the record type.
 type record struct {
 	rtype     recordType
 	segmentID uint16
@@ -54,6 +130,7 @@ type record struct {
 	data      []byte
 	key       []byte
 	value     []byte
+	extra     []byte // Type-specific data of extended records.
 }
 
 func encodedRecordSize(kvSize uint32) uint32 {
@@ -68,7 +145,7 @@ func encodeRecord(key []byte, value []byte, rt recordType) []byte {
 
 	valLen := uint32(len(value))
 	if rt == recordTypeDelete { // Set delete bit.
-		valLen |= 1 << 31
+		valLen |= deleteBit
 	}
 	binary.LittleEndian.PutUint32(data[2:], valLen)
 
@@ -79,6 +156,22 @@ func encodeRecord(key []byte, value []byte, rt recordType) []byte {
 	return data
 }
 
+func encodeExtendedRecord(key []byte, value []byte, rt recordType, extra []byte) []byte {
+	kvSize := uint32(len(key) + len(value))
+	size := encodedRecordSize(kvSize) + 1 + uint32(len(extra))
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint16(data[:2], uint16(len(key)))
+	binary.LittleEndian.PutUint32(data[2:], uint32(len(value))|extendedBit)
+
+	copy(data[6:], key)
+	copy(data[6+len(key):], value)
+	data[6+kvSize] = byte(rt)
+	copy(data[6+kvSize+1:], extra)
+	checksum := crc32.ChecksumIEEE(data[:size-4])
+	binary.LittleEndian.PutUint32(data[size-4:size], checksum)
+	return data
+}
+
 func encodePutRecord(key []byte, value []byte) []byte {
 	return encodeRecord(key, value, recordTypePut)
 }
@@ -87,6 +180,72 @@ func encodeDeleteRecord(key []byte) []byte {
 	return encodeRecord(key, nil, recordTypeDelete)
 }
 
+func encodeBatchRecord(n uint32) []byte {
+	extra := make([]byte, 4)
+	binary.LittleEndian.PutUint32(extra, n)
+	return encodeExtendedRecord(nil, nil, recordTypeBatch, extra)
+}
+
+// batchSize returns the number of records in the batch following the batch record.
+func (rec record) batchSize() uint32 {
+	return binary.LittleEndian.Uint32(rec.extra)
+}
+
+func encodePutTTLRecord(key []byte, value []byte, expiresAt int64) []byte {
+	extra := make([]byte, 8)
+	binary.LittleEndian.PutUint64(extra, uint64(expiresAt))
+	return encodeExtendedRecord(key, value, recordTypePutTTL, extra)
+}
+
+// encodeMergeRecord encodes a merge record holding the merge operand.
+// The merge record points to the previous record of the key, which holds either the previous merge operand or the
+// base value. A zero prev offset means the key has no previous record.
+func encodeMergeRecord(key []byte, operand []byte, prev slot, depth uint16, expiresAt int64) []byte {
+	extra := make([]byte, 16)
+	binary.LittleEndian.PutUint32(extra[:4], prev.offset)
+	binary.LittleEndian.PutUint16(extra[4:6], prev.segmentID)
+	binary.LittleEndian.PutUint16(extra[6:8], depth)
+	binary.LittleEndian.PutUint64(extra[8:], uint64(expiresAt))
+	return encodeExtendedRecord(key, operand, recordTypeMerge, extra)
+}
+
+// mergePrev returns the segment ID and the offset of the previous record of the merge record.
+// The returned bool is false if the merge record has no previous record.
+func (rec record) mergePrev() (uint16, uint32, bool) {
+	offset := binary.LittleEndian.Uint32(rec.extra[:4])
+	return binary.LittleEndian.Uint16(rec.extra[4:6]), offset, offset != 0
+}
+
+// mergeDepth returns the number of previous merge records in the merge chain.
+func (rec record) mergeDepth() uint16 {
+	if rec.rtype != recordTypeMerge {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(rec.extra[6:8])
+}
+
+// decodeExpiresAt returns the expiration time of an encoded put record with expiration time or a merge record.
+func decodeExpiresAt(data []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(data[len(data)-12 : len(data)-4]))
+}
+
+// expiresAt returns the expiration time of the record in Unix nanoseconds or 0 if the record doesn't expire.
+func (rec record) expiresAt() int64 {
+	switch rec.rtype {
+	case recordTypePutTTL:
+		return int64(binary.LittleEndian.Uint64(rec.extra))
+	case recordTypeMerge:
+		return int64(binary.LittleEndian.Uint64(rec.extra[8:]))
+	}
+	return 0
+}
+
+// expired returns true if the record is expired at the given time.
+func (rec record) expired(now int64) bool {
+	expiresAt := rec.expiresAt()
+	return expiresAt != 0 && expiresAt <= now
+}
+
 // segmentIterator iterates over segment records.
 type segmentIterator struct {
 	f      *segment
@@ -107,6 +266,11 @@ func newSegmentIterator(f *segment) (*segmentIterator, error) {
 	}, nil
 }
 
+// corrupted returns a CorruptionError for the record at the current offset.
+func (it *segmentIterator) corrupted(reason string) error {
+	return &CorruptionError{File: it.f.name, Offset: int64(it.offset), Reason: reason}
+}
+
 func (it *segmentIterator) next() (record, error) {
 	// Read key and value size.
 	kvSizeBuf := it.buf
@@ -123,23 +287,44 @@ func (it *segmentIterator) next() (record, error) {
 	// Decode value size and record type.
 	rt := recordTypePut
 	valueSize := binary.LittleEndian.Uint32(kvSizeBuf[2:])
-	if valueSize&(1<<31) != 0 {
+	extended := valueSize&extendedBit != 0
+	if valueSize&deleteBit != 0 {
 		rt = recordTypeDelete
-		valueSize &^= 1 << 31
+	}
+	valueSize &^= deleteBit | extendedBit
+	if valueSize > MaxValueLength || (extended && rt == recordTypeDelete) {
+		return record{}, it.corrupted("invalid record size")
 	}
 
 	// Read key, value and checksum.
+	kvEnd := 6 + keySize + valueSize
 	recordSize := encodedRecordSize(keySize + valueSize)
+	var extraSize uint32
+	if extended {
+		// Read the record type first to find out the size of the type-specific data.
+		head := make([]byte, kvEnd+1)
+		copy(head, kvSizeBuf)
+		if _, err := io.ReadFull(it.r, head[6:]); err != nil {
+			return record{}, err
+		}
+		rt = recordType(head[kvEnd])
+		var ok bool
+		if extraSize, ok = rt.extraSize(); !ok {
+			return record{}, it.corrupted("unknown record type")
+		}
+		recordSize += 1 + extraSize
+		kvSizeBuf = head
+	}
 	data := make([]byte, recordSize)
-	copy(data, kvSizeBuf)
-	if _, err := io.ReadFull(it.r, data[6:]); err != nil {
+	n := copy(data, kvSizeBuf)
+	if _, err := io.ReadFull(it.r, data[n:]); err != nil {
 		return record{}, err
 	}
 
 	// Verify checksum.
 	checksum := binary.LittleEndian.Uint32(data[len(data)-4:])
 	if checksum != crc32.ChecksumIEEE(data[:len(data)-4]) {
-		return record{}, errCorrupted
+		return record{}, it.corrupted("checksum mismatch")
 	}
 
 	offset := it.offset
@@ -150,7 +335,10 @@ func (it *segmentIterator) next() (record, error) {
 		offset:    offset,
 		data:      data,
 		key:       data[6 : 6+keySize],
-		value:     data[6+keySize : 6+keySize+valueSize],
+		value:     data[6+keySize : kvEnd],
+	}
+	if extended {
+		rec.extra = data[kvEnd+1 : kvEnd+1+extraSize]
 	}
 	return rec, nil
 }
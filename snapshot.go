@@ -0,0 +1,167 @@
+package pogreb
+
+// snapshotEntry holds the state of a key at the time the snapshot was created.
+type snapshotEntry struct {
+	exists bool
+	slot   slot
+}
+
+// Snapshot is a read-only view of the DB at the point in time the snapshot was created.
+// Keys modified after the snapshot was created retain their original values in the snapshot.
+// The snapshot keeps track of the modified keys in memory, long-living snapshots increase memory usage and prevent
+// compaction from removing segments holding the original values.
+// The Snapshot must be closed after use, by calling Close method.
+type Snapshot struct {
+	db             *DB
+	level          uint8
+	splitBucketIdx uint32
+	numBuckets     uint32
+	now            int64                    // Time the snapshot was created used to check expiration of keys.
+	entries        map[string]snapshotEntry // Original state of the keys modified after the snapshot was created.
+	bucketEntries  map[uint32][]string      // Keys of entries grouped by snapshot bucket index.
+	closed         bool
+}
+
+// Snapshot returns a new Snapshot of the DB.
+func (db *DB) Snapshot() *Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s := &Snapshot{
+		db:             db,
+		level:          db.index.level,
+		splitBucketIdx: db.index.splitBucketIdx,
+		numBuckets:     db.index.numBuckets,
+		now:            db.now(),
+		entries:        make(map[string]snapshotEntry),
+		bucketEntries:  make(map[uint32][]string),
+	}
+	db.snapshots[s] = struct{}{}
+	return s
+}
+
+// trackSnapshots saves the original state of the key in all open snapshots before the key is modified.
+// sl is the slot of the key before the modification, exists reports whether the key existed.
+func (db *DB) trackSnapshots(key []byte, sl slot, exists bool) {
+	for s := range db.snapshots {
+		if _, ok := s.entries[string(key)]; ok {
+			// The snapshot already holds the original state of the key.
+			continue
+		}
+		if exists {
+			// Prevent compaction from removing the segment holding the original value.
+			db.datalog.ref(sl.segmentID)
+		}
+		k := string(key)
+		s.entries[k] = snapshotEntry{exists: exists, slot: sl}
+		bidx := s.bucketIndex(sl.hash)
+		s.bucketEntries[bidx] = append(s.bucketEntries[bidx], k)
+	}
+}
+
+// closeSnapshots closes all open snapshots.
+func (db *DB) closeSnapshots() error {
+	for s := range db.snapshots {
+		if err := s.release(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucketIndex returns the index of the bucket the hash belonged to at the time the snapshot was created.
+func (s *Snapshot) bucketIndex(hash uint32) uint32 {
+	return bucketIndex(hash, s.level, s.splitBucketIdx)
+}
+
+// bucketBits returns the number of hash bits used to address the snapshot bucket.
+func (s *Snapshot) bucketBits(bucketIdx uint32) uint8 {
+	if bucketIdx < s.splitBucketIdx || bucketIdx >= 1<<s.level {
+		return s.level + 1
+	}
+	return s.level
+}
+
+// Get returns the value for the given key stored in the snapshot or nil if the key doesn't exist.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	h := s.db.hash(key)
+	s.db.metrics.Gets.Add(1)
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.closed {
+		return nil, ErrSnapshotClosed
+	}
+	var value []byte
+	if e, ok := s.entries[string(key)]; ok {
+		if !e.exists {
+			return nil, nil
+		}
+		rec, err := s.db.datalog.lookupRecord(e.slot, true)
+		if err != nil || rec.expired(s.now) {
+			return nil, err
+		}
+		if value, err = s.db.recordValue(rec); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if value, err = s.db.get(h, key, s.now); err != nil || value == nil {
+			return nil, err
+		}
+	}
+	return cloneBytes(value), nil
+}
+
+// Has returns true if the snapshot contains the given key.
+func (s *Snapshot) Has(key []byte) (bool, error) {
+	h := s.db.hash(key)
+	s.db.metrics.Gets.Add(1)
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	if s.closed {
+		return false, ErrSnapshotClosed
+	}
+	if e, ok := s.entries[string(key)]; ok {
+		if !e.exists {
+			return false, nil
+		}
+		rec, err := s.db.datalog.lookupRecord(e.slot, false)
+		if err != nil {
+			return false, err
+		}
+		return !rec.expired(s.now), nil
+	}
+	return s.db.has(h, key, s.now)
+}
+
+// Items returns a new ItemIterator over the snapshot items.
+func (s *Snapshot) Items() *ItemIterator {
+	return &ItemIterator{db: s.db, snap: s}
+}
+
+// release releases resources held by the snapshot.
+func (s *Snapshot) release() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	delete(s.db.snapshots, s)
+	var firstErr error
+	for _, e := range s.entries {
+		if !e.exists {
+			continue
+		}
+		if err := s.db.datalog.unref(e.slot.segmentID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.entries = nil
+	s.bucketEntries = nil
+	return firstErr
+}
+
+// Close releases the snapshot.
+func (s *Snapshot) Close() error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return s.release()
+}
@@ -0,0 +1,160 @@
+package pogreb
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func snapshotItems(t *testing.T, it *ItemIterator) map[byte]byte {
+	t.Helper()
+	items := make(map[byte]byte)
+	for {
+		key, value, err := it.Next()
+		if err == ErrIterationDone {
+			break
+		}
+		assert.Nil(t, err)
+		if _, ok := items[key[0]]; ok {
+			t.Fatalf("duplicate key %v", key)
+		}
+		items[key[0]] = value[0]
+	}
+	return items
+}
+
+func TestSnapshot(t *testing.T) {
+	db, err := createTestDB(nil)
+	assert.Nil(t, err)
+
+	expected := make(map[byte]byte)
+	for i := byte(0); i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+		expected[i] = i
+	}
+
+	s := db.Snapshot()
+
+	// Overwrite, delete and insert keys, the index is split multiple times.
+	for i := byte(0); i < 50; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i + 1}))
+	}
+	for i := byte(50); i < 60; i++ {
+		assert.Nil(t, db.Delete([]byte{i}))
+	}
+	for i := byte(100); i < 255; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+	}
+	assert.Equal(t, uint32(245), db.Count())
+
+	for i := byte(0); i < 255; i++ {
+		v, err := s.Get([]byte{i})
+		assert.Nil(t, err)
+		has, err := s.Has([]byte{i})
+		assert.Nil(t, err)
+		if i < 100 {
+			assert.Equal(t, []byte{i}, v)
+			assert.Equal(t, true, has)
+		} else {
+			assert.Nil(t, v)
+			assert.Equal(t, false, has)
+		}
+	}
+	assert.Equal(t, expected, snapshotItems(t, s.Items()))
+
+	// Modifying the same key again doesn't affect the snapshot.
+	assert.Nil(t, db.Put([]byte{0}, []byte{2}))
+	assert.Nil(t, db.Delete([]byte{100}))
+	v, err := s.Get([]byte{0})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0}, v)
+
+	// Iterating while writing.
+	it := s.Items()
+	_, _, err = it.Next()
+	assert.Nil(t, err)
+	for i := byte(60); i < 100; i++ {
+		assert.Nil(t, db.Delete([]byte{i}))
+	}
+	items := snapshotItems(t, it)
+	assert.Equal(t, len(expected)-1, len(items))
+
+	// The DB isn't affected by the snapshot.
+	v, err = db.Get([]byte{0})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, v)
+
+	assert.Nil(t, s.Close())
+	assert.Nil(t, s.Close())
+	_, err = s.Get([]byte{0})
+	assert.Equal(t, ErrSnapshotClosed, err)
+	_, err = s.Has([]byte{0})
+	assert.Equal(t, ErrSnapshotClosed, err)
+	_, _, err = s.Items().Next()
+	assert.Equal(t, ErrSnapshotClosed, err)
+
+	assert.Nil(t, db.Close())
+}
+
+func TestSnapshotCompaction(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	// Fill segment 0.
+	for i := byte(0); i < 42; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+	}
+	s := db.Snapshot()
+	// Overwrite keys in segment 1.
+	for i := byte(0); i < 10; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i + 1}))
+	}
+	assert.Equal(t, 2, countSegments(t, db))
+
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, CompactionResult{CompactedSegments: 1, ReclaimedRecords: 10, ReclaimedBytes: 120}, cr)
+
+	// The compacted segment is referenced by the snapshot.
+	assert.Equal(t, 2, countSegments(t, db))
+	assert.Equal(t, true, db.datalog.segments[0].compacted)
+	assert.Equal(t, true, fileExists(filepath.Join(testDBName, segmentName(0, 1))))
+
+	// Compacted segments are not picked for compaction again.
+	cr, err = db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, CompactionResult{}, cr)
+
+	for i := byte(0); i < 42; i++ {
+		v, err := s.Get([]byte{i})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{i}, v)
+	}
+
+	assert.Nil(t, s.Close())
+	assert.Equal(t, 1, countSegments(t, db))
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, segmentName(0, 1))))
+
+	for i := byte(0); i < 42; i++ {
+		v, err := db.Get([]byte{i})
+		assert.Nil(t, err)
+		if i < 10 {
+			assert.Equal(t, []byte{i + 1}, v)
+		} else {
+			assert.Equal(t, []byte{i}, v)
+		}
+	}
+
+	// Closing the DB releases open snapshots.
+	s = db.Snapshot()
+	assert.Nil(t, db.Put([]byte{0}, []byte{0}))
+	assert.Nil(t, db.Close())
+	_, err = s.Get([]byte{0})
+	assert.Equal(t, ErrSnapshotClosed, err)
+}
@@ -0,0 +1,109 @@
+package pogreb
+
+import "time"
+
+// Stats holds detailed DB statistics.
+type Stats struct {
+	Segments []SegmentStats // Segments ordered from oldest to newest.
+	Index    IndexStats
+}
+
+// SegmentStats holds statistics of a write-ahead log segment.
+type SegmentStats struct {
+	ID            uint16
+	SequenceID    uint64
+	Size          int64
+	PutRecords    uint32
+	DeleteRecords uint32
+	MergeRecords  uint32
+	DeletedKeys   uint32
+	DeletedBytes  uint32
+	Fragmentation float32 // Ratio of the deleted and expired records size to the segment size.
+	Full          bool    // The segment is full and no longer written to.
+
+	ExpiringRecords uint32    // Number of put records with expiration time.
+	MinExpiresAt    time.Time // Earliest expiration time of put records, zero if there are no expiring records.
+	MaxExpiresAt    time.Time // Latest expiration time of put records, zero if there are no expiring records.
+}
+
+// IndexStats holds statistics of the hash index.
+type IndexStats struct {
+	Level               uint8   // Maximum number of buckets on a logarithmic scale.
+	NumKeys             uint32  // Number of keys.
+	NumBuckets          uint32  // Number of buckets in the main index file.
+	SplitBucketIndex    uint32  // Index of the bucket to split on next split.
+	OverflowBuckets     int     // Number of overflow buckets in use.
+	FreeOverflowBuckets int     // Number of freed overflow buckets available for reuse.
+	LoadFactor          float64 // Ratio of the number of keys to the number of slots in the main index buckets.
+	LongestChain        int     // Maximum number of buckets in a bucket chain, including overflow buckets.
+}
+
+// Stats returns detailed statistics of the DB segments and index.
+// Stats walks all index buckets; writes are blocked while the statistics are collected.
+func (db *DB) Stats() (Stats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.datalog.changes.closed {
+		return Stats{}, ErrClosed
+	}
+
+	stats := Stats{}
+	now := db.now()
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if seg.compacted {
+			continue
+		}
+		stats.Segments = append(stats.Segments, seg.stats(now))
+	}
+
+	idx := db.index
+	stats.Index = IndexStats{
+		Level:               idx.level,
+		NumKeys:             idx.numKeys,
+		NumBuckets:          idx.numBuckets,
+		SplitBucketIndex:    idx.splitBucketIdx,
+		FreeOverflowBuckets: len(idx.freeBucketOffs),
+		LoadFactor:          float64(idx.numKeys) / float64(idx.numBuckets*slotsPerBucket),
+	}
+	for bidx := uint32(0); bidx < idx.numBuckets; bidx++ {
+		it := idx.newBucketIterator(bidx)
+		chain := 0
+		for {
+			_, err := it.next()
+			if err == ErrIterationDone {
+				break
+			}
+			if err != nil {
+				return Stats{}, err
+			}
+			chain++
+		}
+		stats.Index.OverflowBuckets += chain - 1
+		if chain > stats.Index.LongestChain {
+			stats.Index.LongestChain = chain
+		}
+	}
+	return stats, nil
+}
+
+// stats returns statistics of the segment at the given time.
+func (seg *segment) stats(now int64) SegmentStats {
+	stats := SegmentStats{
+		ID:              seg.id,
+		SequenceID:      seg.sequenceID,
+		Size:            seg.size,
+		PutRecords:      seg.meta.PutRecords,
+		DeleteRecords:   seg.meta.DeleteRecords,
+		MergeRecords:    seg.meta.MergeRecords,
+		DeletedKeys:     seg.meta.DeletedKeys,
+		DeletedBytes:    seg.meta.DeletedBytes,
+		Fragmentation:   seg.fragmentation(now),
+		Full:            seg.meta.Full,
+		ExpiringRecords: seg.meta.ExpiringRecords,
+	}
+	if seg.meta.ExpiringRecords > 0 {
+		stats.MinExpiresAt = time.Unix(0, seg.meta.MinExpiresAt)
+		stats.MaxExpiresAt = time.Unix(0, seg.meta.MaxExpiresAt)
+	}
+	return stats
+}
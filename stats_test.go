@@ -0,0 +1,82 @@
+package pogreb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestStats(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.01,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	stats, err := db.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, Stats{
+		Segments: []SegmentStats{{ID: 0, SequenceID: 1, Size: int64(headerSize)}},
+		Index:    IndexStats{NumBuckets: 1, LongestChain: 1},
+	}, stats)
+
+	// The first segment fits 42 items (12 bytes per item, 1 byte key, 1 byte value).
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	assert.Nil(t, db.Delete([]byte{0}))
+
+	stats, err = db.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, []SegmentStats{
+		{
+			ID:            0,
+			SequenceID:    1,
+			Size:          1016,
+			PutRecords:    42,
+			DeletedKeys:   1,
+			DeletedBytes:  12,
+			Fragmentation: float32(12) / 1016,
+			Full:          true,
+		},
+		{
+			ID:            1,
+			SequenceID:    2,
+			Size:          int64(headerSize) + 8*12 + 11,
+			PutRecords:    8,
+			DeleteRecords: 1,
+			DeletedBytes:  11,
+			Fragmentation: float32(11) / float32(int64(headerSize)+8*12+11),
+		},
+	}, stats.Segments)
+
+	idx := stats.Index
+	assert.Equal(t, uint32(49), idx.NumKeys)
+	assert.Equal(t, float64(49)/float64(idx.NumBuckets*slotsPerBucket), idx.LoadFactor)
+	assert.Equal(t, true, idx.LongestChain >= 1)
+	totalOverflow := int((db.index.overflow.size - int64(headerSize)) / bucketSize)
+	assert.Equal(t, totalOverflow, idx.OverflowBuckets+idx.FreeOverflowBuckets)
+	report, err := db.Verify(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, report.Buckets, int(idx.NumBuckets)+idx.OverflowBuckets)
+
+	// Compacted segments are excluded.
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, cr.CompactedSegments)
+	stats, err = db.Stats()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(stats.Segments))
+	for _, seg := range stats.Segments {
+		assert.Equal(t, true, seg.SequenceID > 2)
+		assert.Equal(t, uint32(0), seg.DeletedBytes)
+		assert.Equal(t, float32(0), seg.Fragmentation)
+	}
+
+	assert.Nil(t, db.Close())
+	_, err = db.Stats()
+	assert.Equal(t, ErrClosed, err)
+}
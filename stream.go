@@ -0,0 +1,271 @@
+package pogreb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// streamBufferSize is the size of the buffer used to copy streamed values to segment files.
+	streamBufferSize = 64 << 10
+
+	stagingExt = ".stg"
+)
+
+// PutReader sets the value for the given key reading exactly size bytes from r.
+// It updates the value for the existing key.
+// Values up to 64 KiB are read into memory and written like Put.
+// Larger values are copied in chunks without materializing them in memory: the record is written to a new segment
+// file without holding the DB lock, reading from r doesn't block other readers and writers. Then the segment becomes
+// the current segment while the DB write lock is held, the value isn't copied again.
+// Nothing is written to the DB if r returns an error or fewer than size bytes.
+func (db *DB) PutReader(key []byte, r io.Reader, size int64) error {
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(key) > MaxKeyLength {
+		return ErrKeyTooLarge
+	}
+	if size < 0 {
+		return ErrInvalidValueSize
+	}
+	if size > MaxValueLength {
+		return ErrValueTooLarge
+	}
+	if size <= streamBufferSize {
+		value := make([]byte, size)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		return db.putValue(key, value, 0)
+	}
+	defer db.metrics.PutLatency.observeSince(time.Now())
+	h := db.hash(key)
+	db.metrics.Puts.Add(1)
+
+	staged, name, err := db.createStagingFile()
+	if err != nil {
+		return err
+	}
+	w := &offsetWriter{f: staged, off: staged.size}
+	if err := writeStreamRecord(w, key, r, uint32(size)); err != nil {
+		db.removeStagingFile(staged, name)
+		return err
+	}
+	staged.size = w.off
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.following {
+		db.discardStagingFile(staged, name)
+		return ErrReadOnly
+	}
+
+	segID, offset, err := db.datalog.addStreamSegment(staged, name)
+	if err != nil {
+		return err
+	}
+
+	sl := slot{
+		hash:      h,
+		segmentID: segID,
+		keySize:   uint16(len(key)),
+		valueSize: uint32(size),
+		offset:    offset,
+	}
+
+	if err := db.put(sl, key); err != nil {
+		return err
+	}
+
+	if db.syncWrites {
+		return db.sync()
+	}
+	return nil
+}
+
+// writeStreamRecord writes the put record reading the value of valueSize bytes from r to w.
+// The checksum is computed incrementally.
+func writeStreamRecord(w io.Writer, key []byte, r io.Reader, valueSize uint32) error {
+	head := make([]byte, 6+len(key))
+	binary.LittleEndian.PutUint16(head[:2], uint16(len(key)))
+	binary.LittleEndian.PutUint32(head[2:6], valueSize)
+	copy(head[6:], key)
+
+	h := crc32.NewIEEE()
+	mw := io.MultiWriter(w, h)
+	if _, err := mw.Write(head); err != nil {
+		return err
+	}
+	buf := make([]byte, streamBufferSize)
+	n, err := io.CopyBuffer(mw, io.LimitReader(r, int64(valueSize)), buf)
+	if err != nil {
+		return err
+	}
+	if n != int64(valueSize) {
+		return io.ErrUnexpectedEOF
+	}
+	var checksum [4]byte
+	binary.LittleEndian.PutUint32(checksum[:], h.Sum32())
+	_, err = w.Write(checksum[:])
+	return err
+}
+
+// createStagingFile creates a temporary segment file in the DB directory.
+// Staging files left behind when the DB isn't closed properly are removed by recovery.
+func (db *DB) createStagingFile() (*file, string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.stagingSeq++
+	name := strconv.FormatUint(db.stagingSeq, 10) + stagingExt
+	f, err := openFile(db.opts.FileSystem, name, openFileFlags{truncate: true})
+	if err != nil {
+		return nil, "", err
+	}
+	return f, name, nil
+}
+
+// removeStagingFile closes and removes the staging file.
+func (db *DB) removeStagingFile(f *file, name string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.discardStagingFile(f, name)
+}
+
+// discardStagingFile closes and removes the staging file while the DB write lock is held.
+func (db *DB) discardStagingFile(f *file, name string) {
+	err := f.Close()
+	if rerr := db.opts.FileSystem.Remove(name); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		db.opts.Logger.Error("error removing staging file", "file", name, "err", err)
+	}
+}
+
+// GetReader returns a reader streaming the value for the given key stored in the DB.
+// It returns ErrKeyNotFound if the key doesn't exist.
+// The value is read from the segment file in chunks, the DB read lock is held only while reading a chunk.
+// The reader returns the value the key had when GetReader was called, even if the key is modified later.
+// The reader must be closed after use, by calling Close method. An open reader prevents compaction from removing the
+// segment holding the value.
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	defer db.metrics.GetLatency.observeSince(time.Now())
+	h := db.hash(key)
+	db.metrics.Gets.Add(1)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	now := db.now()
+	var rc io.ReadCloser
+	err := db.index.get(h, func(sl slot) (bool, error) {
+		if uint16(len(key)) != sl.keySize {
+			return false, nil
+		}
+		rec, err := db.datalog.lookupRecord(sl, false)
+		if err != nil {
+			return true, err
+		}
+		if !bytes.Equal(key, rec.key) {
+			db.metrics.HashCollisions.Add(1)
+			return false, nil
+		}
+		if rec.expired(now) {
+			return true, nil
+		}
+		if rec.rtype == recordTypeMerge {
+			// Merged values are computed in memory.
+			if rec, err = db.datalog.lookupRecord(sl, true); err != nil {
+				return true, err
+			}
+			value, err := db.recordValue(rec)
+			if err != nil {
+				return true, err
+			}
+			rc = io.NopCloser(bytes.NewReader(cloneBytes(value)))
+			return true, nil
+		}
+		off := int64(sl.offset) + 6 + int64(sl.keySize)
+		vr := &valueReader{
+			db:  db,
+			seg: db.datalog.segments[sl.segmentID],
+			off: off,
+			end: off + int64(sl.valueSize),
+		}
+		// Concurrent GetReader calls hold only the DB read lock.
+		db.valueReadersMu.Lock()
+		db.datalog.ref(sl.segmentID)
+		db.valueReaders[vr] = struct{}{}
+		db.valueReadersMu.Unlock()
+		rc = vr
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rc == nil {
+		return nil, ErrKeyNotFound
+	}
+	return rc, nil
+}
+
+// valueReader reads the value of a record directly from the segment file.
+type valueReader struct {
+	db     *DB
+	seg    *segment
+	off    int64 // Offset of the next byte to read.
+	end    int64 // Offset of the end of the value.
+	closed bool
+}
+
+func (r *valueReader) Read(p []byte) (int, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.off >= r.end {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.end-r.off {
+		p = p[:r.end-r.off]
+	}
+	n, err := r.seg.ReadAt(p, r.off)
+	r.off += int64(n)
+	r.db.metrics.BytesRead.Add(int64(n))
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return n, err
+}
+
+// release releases the segment held by the reader.
+func (r *valueReader) release() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	delete(r.db.valueReaders, r)
+	return r.db.datalog.unref(r.seg.id)
+}
+
+// Close releases the reader.
+func (r *valueReader) Close() error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	return r.release()
+}
+
+// closeValueReaders closes all open value readers.
+func (db *DB) closeValueReaders() error {
+	for r := range db.valueReaders {
+		if err := r.release(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
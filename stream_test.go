@@ -0,0 +1,197 @@
+package pogreb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestPutReader(t *testing.T) {
+	opts := &Options{FileSystem: testFS}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	value := make([]byte, streamBufferSize*3+1)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	assert.Nil(t, db.PutReader([]byte{1}, bytes.NewReader(value), int64(len(value))))
+	assert.Nil(t, db.PutReader([]byte{2}, bytes.NewReader(nil), 0))
+	v, err := db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, value, v)
+	v, err = db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, v)
+	// The large value is written to a new segment, the small value is written like Put.
+	assert.Equal(t, &segmentMeta{Full: true}, db.datalog.segments[0].meta)
+	assert.Equal(t, &segmentMeta{PutRecords: 2}, db.datalog.segments[1].meta)
+	assert.Equal(t, db.datalog.segments[1], db.datalog.curSeg)
+	assert.Equal(t, segmentName(1, 2), db.datalog.curSeg.name)
+
+	// Failed writes are discarded.
+	size := db.datalog.curSeg.size
+	err = db.PutReader([]byte{1}, bytes.NewReader(value[:10]), 11)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	err = db.PutReader([]byte{1}, bytes.NewReader(value[:10]), streamBufferSize+1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	errRead := errors.New("read error")
+	err = db.PutReader([]byte{1}, iotest.ErrReader(errRead), 1)
+	assert.Equal(t, errRead, err)
+	err = db.PutReader([]byte{1}, iotest.ErrReader(errRead), streamBufferSize+1)
+	assert.Equal(t, errRead, err)
+	assert.Equal(t, size, db.datalog.curSeg.size)
+	assert.Equal(t, 2, countSegments(t, db))
+	assert.Equal(t, &segmentMeta{PutRecords: 2}, db.datalog.segments[1].meta)
+	assert.Nil(t, db.Put([]byte{3}, []byte{3}))
+
+	assert.Equal(t, ErrInvalidValueSize, db.PutReader([]byte{1}, bytes.NewReader(nil), -1))
+	assert.Equal(t, ErrValueTooLarge, db.PutReader([]byte{1}, bytes.NewReader(nil), MaxValueLength+1))
+
+	// Reading from a slow reader doesn't block other readers and writers.
+	slowValue := value[:streamBufferSize+2]
+	pr, pw := io.Pipe()
+	errC := make(chan error, 1)
+	go func() {
+		errC <- db.PutReader([]byte{4}, pr, int64(len(slowValue)))
+	}()
+	_, err = pw.Write(slowValue[:1])
+	assert.Nil(t, err)
+	v, err = db.Get([]byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, v)
+	assert.Nil(t, db.Put([]byte{5}, []byte{5}))
+	_, err = pw.Write(slowValue[1:])
+	assert.Nil(t, err)
+	assert.Nil(t, <-errC)
+	v, err = db.Get([]byte{4})
+	assert.Nil(t, err)
+	assert.Equal(t, slowValue, v)
+	assert.Nil(t, db.Delete([]byte{4}))
+	assert.Nil(t, db.Delete([]byte{5}))
+
+	// The key written by PutReader is overwritten by a later Put.
+	assert.Nil(t, db.PutReader([]byte{6}, bytes.NewReader(value), int64(len(value))))
+	assert.Nil(t, db.Put([]byte{6}, []byte{6}))
+
+	// Staging files are removed.
+	files, err := testFS.ReadDir(testDBName)
+	assert.Nil(t, err)
+	for _, file := range files {
+		assert.Equal(t, false, filepath.Ext(file.Name()) == stagingExt)
+	}
+
+	// Recover. Staging files left behind are removed, records are replayed in the order they were written.
+	assert.Nil(t, db.Close())
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, "1"+stagingExt)))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, false, fileExists(filepath.Join(testDBName, "1"+stagingExt)))
+	assert.Equal(t, uint32(4), db.Count())
+	v, err = db.Get([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, value, v)
+	v, err = db.Get([]byte{3})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{3}, v)
+	v, err = db.Get([]byte{6})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{6}, v)
+
+	// Change feeds return the streamed values in the order they were committed.
+	f, err := db.SubscribeFrom(context.Background(), ChangePosition{})
+	assert.Nil(t, err)
+	var keys []byte
+	for _, event := range nextChanges(t, f, 9) {
+		keys = append(keys, event.Key[0])
+	}
+	assert.Equal(t, []byte{1, 2, 3, 5, 4, 4, 5, 6, 6}, keys)
+	assert.Nil(t, db.Close())
+}
+
+func TestGetReader(t *testing.T) {
+	opts := &Options{
+		FileSystem:                 testFS,
+		MergeOperator:              AppendOperator,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	readAll := func(key []byte) []byte {
+		t.Helper()
+		r, err := db.GetReader(key)
+		assert.Nil(t, err)
+		value, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Nil(t, r.Close())
+		return value
+	}
+
+	_, err = db.GetReader([]byte{1})
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.Put([]byte{1}, []byte{1, 2, 3}))
+	assert.Equal(t, []byte{1, 2, 3}, readAll([]byte{1}))
+	assert.Nil(t, db.Merge([]byte{2}, []byte{1}))
+	assert.Nil(t, db.Merge([]byte{2}, []byte{2}))
+	assert.Equal(t, []byte{1, 2}, readAll([]byte{2}))
+
+	// Readers are opened concurrently under the DB read lock.
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			r, err := db.GetReader([]byte{1})
+			if err == nil {
+				_, err = io.ReadAll(r)
+				if cerr := r.Close(); err == nil {
+					err = cerr
+				}
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		assert.Nil(t, <-errs)
+	}
+
+	// Open readers return the original value.
+	r, err := db.GetReader([]byte{1})
+	assert.Nil(t, err)
+	buf := make([]byte, 1)
+	_, err = io.ReadFull(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1}, buf)
+	for countSegments(t, db) == 1 {
+		assert.Nil(t, db.Put([]byte{1}, []byte{4, 5, 6}))
+	}
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cr.CompactedSegments)
+	value, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2, 3}, value)
+	assert.Nil(t, r.Close())
+	assert.Nil(t, r.Close())
+	_, err = r.Read(buf)
+	assert.Equal(t, os.ErrClosed, err)
+	assert.Equal(t, []byte{4, 5, 6}, readAll([]byte{1}))
+
+	// Closing the DB closes open readers.
+	r, err = db.GetReader([]byte{1})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	_, err = r.Read(buf)
+	assert.Equal(t, os.ErrClosed, err)
+	assert.Nil(t, r.Close())
+}
@@ -0,0 +1,177 @@
+package pogreb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+// testClock is a manually advanced clock.
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestClock() *testClock {
+	return &testClock{t: time.Unix(1600000000, 0)}
+}
+
+func TestPutWithTTL(t *testing.T) {
+	clock := newTestClock()
+	db, err := createTestDB(&Options{now: clock.now})
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrInvalidTTL, db.PutWithTTL([]byte{1}, []byte{1}, 0))
+	assert.Equal(t, ErrInvalidTTL, db.PutWithTTL([]byte{1}, []byte{1}, -time.Second))
+
+	assert.Nil(t, db.Put([]byte{1}, []byte{1}))
+	assert.Nil(t, db.PutWithTTL([]byte{2}, []byte{2}, time.Second))
+	assert.Nil(t, db.PutWithTTL([]byte{3}, []byte{3}, time.Hour))
+	assert.Nil(t, db.PutWithTTL([]byte{4}, []byte{4}, time.Second))
+	// Put without TTL removes the expiration time.
+	assert.Nil(t, db.Put([]byte{4}, []byte{4}))
+
+	v, err := db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, v)
+	assert.Equal(t, &segmentMeta{
+		PutRecords:      5,
+		DeletedKeys:     1,
+		DeletedBytes:    21,
+		ExpiringRecords: 3,
+		ExpiringBytes:   42,
+		MinExpiresAt:    clock.t.Add(time.Second).UnixNano(),
+		MaxExpiresAt:    clock.t.Add(time.Hour).UnixNano(),
+	}, db.datalog.segments[0].meta)
+
+	s := db.Snapshot()
+	clock.advance(time.Second)
+
+	v, err = db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Nil(t, v)
+	has, err := db.Has([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	assert.Equal(t, map[byte]byte{1: 1, 3: 3, 4: 4}, snapshotItems(t, db.Items()))
+
+	// Expired keys are counted until they are reclaimed.
+	assert.Equal(t, uint32(4), db.Count())
+
+	// Expiration is checked against the snapshot creation time.
+	v, err = s.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, v)
+	assert.Equal(t, map[byte]byte{1: 1, 2: 2, 3: 3, 4: 4}, snapshotItems(t, s.Items()))
+	assert.Nil(t, s.Close())
+
+	// Overwriting an expired key.
+	assert.Nil(t, db.PutWithTTL([]byte{2}, []byte{5}, time.Second))
+	v, err = db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{5}, v)
+
+	clock.advance(time.Hour)
+	assert.Equal(t, map[byte]byte{1: 1, 4: 4}, snapshotItems(t, db.Items()))
+
+	assert.Nil(t, db.Close())
+}
+
+func TestPutWithTTLRecovery(t *testing.T) {
+	clock := newTestClock()
+	opts := &Options{FileSystem: testFS, now: clock.now}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.PutWithTTL([]byte{1}, []byte{1}, time.Second))
+	assert.Nil(t, db.PutWithTTL([]byte{2}, []byte{2}, time.Hour))
+	assert.Nil(t, db.Close())
+
+	// Expiration time is persisted.
+	clock.advance(time.Second)
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	has, err := db.Has([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, false, has)
+	assert.Equal(t, uint32(2), db.Count())
+	assert.Nil(t, db.Close())
+
+	// Simulate crash.
+	assert.Nil(t, touchFile(testFS, filepath.Join(testDBName, lockName)))
+
+	// Recovery discards expired keys.
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), db.Count())
+	v, err := db.Get([]byte{2})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{2}, v)
+	assert.Equal(t, &segmentMeta{
+		PutRecords:      2,
+		DeletedKeys:     1,
+		DeletedBytes:    21,
+		ExpiringRecords: 2,
+		ExpiringBytes:   21,
+		MinExpiresAt:    clock.t.UnixNano(),
+		MaxExpiresAt:    clock.t.Add(time.Hour - time.Second).UnixNano(),
+	}, db.datalog.segments[0].meta)
+	assert.Nil(t, db.Close())
+}
+
+func TestPutWithTTLCompaction(t *testing.T) {
+	clock := newTestClock()
+	opts := &Options{
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+		now:                        clock.now,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+
+	for i := byte(0); i < 10; i++ {
+		assert.Nil(t, db.PutWithTTL([]byte{i}, []byte{i}, time.Second))
+	}
+	for i := byte(10); i < 20; i++ {
+		assert.Nil(t, db.Put([]byte{i}, []byte{i}))
+	}
+
+	// Keys are not expired yet.
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, CompactionResult{}, cr)
+
+	s := db.Snapshot()
+	clock.advance(time.Second)
+
+	cr, err = db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, CompactionResult{CompactedSegments: 1, ReclaimedRecords: 10, ReclaimedBytes: 210}, cr)
+	assert.Equal(t, uint32(10), db.Count())
+	assert.Equal(t, &segmentMeta{PutRecords: 10}, db.datalog.segments[1].meta)
+	for i := byte(0); i < 20; i++ {
+		has, err := db.Has([]byte{i})
+		assert.Nil(t, err)
+		assert.Equal(t, i >= 10, has)
+	}
+
+	// The snapshot still holds the expired keys.
+	for i := byte(0); i < 10; i++ {
+		v, err := s.Get([]byte{i})
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{i}, v)
+	}
+	assert.Nil(t, s.Close())
+	assert.Equal(t, 1, countSegments(t, db))
+
+	assert.Nil(t, db.Close())
+}
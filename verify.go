@@ -0,0 +1,248 @@
+package pogreb
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/akrylysov/pogreb/internal/errors"
+)
+
+// VerifyReport holds the result of the DB integrity verification.
+type VerifyReport struct {
+	Segments int                // Number of verified segments.
+	Records  int                // Number of verified segment records.
+	Buckets  int                // Number of verified index buckets, including overflow buckets.
+	Keys     int                // Number of keys found in the index.
+	Problems []*CorruptionError // Problems found in the DB files.
+}
+
+// OK returns true if no problems were found.
+func (r VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// verifier checks the DB files for corruption.
+type verifier struct {
+	ctx       context.Context
+	db        *DB
+	report    VerifyReport
+	scanned   map[uint16]bool  // Segments with all records successfully verified.
+	liveBytes map[uint16]int64 // Size of the records pointed to by the index per segment.
+}
+
+// Verify checks the integrity of the DB.
+// It verifies the checksums of all segment records, checks that every index slot points to a valid record and that
+// the index and segment metadata match the DB files.
+// Writes are blocked while the verification is running.
+// Problems found in the DB files are returned in the VerifyReport, the error is returned only if the verification
+// can't be completed.
+func (db *DB) Verify(ctx context.Context) (VerifyReport, error) {
+	// Make sure the compaction is not running during verification.
+	db.maintenanceMu.Lock()
+	defer db.maintenanceMu.Unlock()
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.datalog.changes.closed {
+		return VerifyReport{}, ErrClosed
+	}
+
+	v := &verifier{
+		ctx:       ctx,
+		db:        db,
+		scanned:   make(map[uint16]bool),
+		liveBytes: make(map[uint16]int64),
+	}
+	for _, seg := range db.datalog.segmentsBySequenceID() {
+		if err := v.verifySegment(seg); err != nil {
+			return VerifyReport{}, err
+		}
+	}
+	if err := v.verifyIndex(); err != nil {
+		return VerifyReport{}, err
+	}
+	v.verifySegmentMeta()
+	return v.report, nil
+}
+
+func (v *verifier) problem(file string, offset int64, format string, args ...interface{}) {
+	v.report.Problems = append(v.report.Problems, &CorruptionError{
+		File:   file,
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// verifySegment verifies the segment header and the checksums of the segment records.
+func (v *verifier) verifySegment(seg *segment) error {
+	v.report.Segments++
+	buf, err := seg.Slice(0, int64(headerSize))
+	if err != nil {
+		return err
+	}
+	h := &header{}
+	if err := h.UnmarshalBinary(buf); err != nil {
+		v.problem(seg.name, 0, "invalid file signature")
+		return nil
+	}
+
+	// Read only the records written before the verification started.
+	it := &segmentIterator{
+		f:      seg,
+		offset: headerSize,
+		r:      bufio.NewReader(io.NewSectionReader(seg, int64(headerSize), seg.size-int64(headerSize))),
+		buf:    make([]byte, 6),
+	}
+	for {
+		if err := v.ctx.Err(); err != nil {
+			return err
+		}
+		_, err := it.next()
+		if err == ErrIterationDone {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = it.corrupted("truncated record")
+		}
+		var cerr *CorruptionError
+		if errors.As(err, &cerr) {
+			// Records following the corrupted record can't be located.
+			v.report.Problems = append(v.report.Problems, cerr)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		v.report.Records++
+	}
+	v.scanned[seg.id] = true
+	return nil
+}
+
+// verifyIndex walks all index buckets and checks every slot.
+func (v *verifier) verifyIndex() error {
+	idx := v.db.index
+	usedOverflow := make(map[int64]bool)
+	validOverflowOffset := func(off int64) bool {
+		return off >= int64(headerSize) && (off-int64(headerSize))%bucketSize == 0 && off+bucketSize <= idx.overflow.size
+	}
+
+	for bidx := uint32(0); bidx < idx.numBuckets; bidx++ {
+		b := bucketHandle{file: idx.main, offset: bucketOffset(bidx)}
+		name := indexMainName
+		for {
+			if err := v.ctx.Err(); err != nil {
+				return err
+			}
+			if err := b.read(); err != nil {
+				return err
+			}
+			v.report.Buckets++
+			for i := 0; i < slotsPerBucket; i++ {
+				sl := b.slots[i]
+				if sl.offset == 0 {
+					// No more slots in the bucket.
+					break
+				}
+				v.report.Keys++
+				if reason := v.verifySlot(sl, bidx); reason != "" {
+					v.problem(name, b.offset, "slot %d %s", i, reason)
+				}
+			}
+			if b.next == 0 {
+				break
+			}
+			if !validOverflowOffset(b.next) {
+				v.problem(name, b.offset, "invalid overflow bucket offset %d", b.next)
+				break
+			}
+			if usedOverflow[b.next] {
+				v.problem(name, b.offset, "overflow bucket %d is linked multiple times", b.next)
+				break
+			}
+			usedOverflow[b.next] = true
+			b = bucketHandle{file: idx.overflow, offset: b.next}
+			name = indexOverflowName
+		}
+	}
+
+	freeOverflow := make(map[int64]bool)
+	for _, off := range idx.freeBucketOffs {
+		if !validOverflowOffset(off) || usedOverflow[off] || freeOverflow[off] {
+			v.problem(indexMetaName, 0, "invalid free overflow bucket %d", off)
+		}
+		freeOverflow[off] = true
+	}
+	for off := int64(headerSize); off+bucketSize <= idx.overflow.size; off += bucketSize {
+		if !usedOverflow[off] && !freeOverflow[off] {
+			v.problem(indexOverflowName, off, "orphaned overflow bucket")
+		}
+	}
+
+	if uint32(v.report.Keys) != idx.numKeys {
+		v.problem(indexMetaName, 0, "number of keys %d doesn't match %d keys in the index", idx.numKeys, v.report.Keys)
+	}
+	return nil
+}
+
+// verifySlot checks that the slot in the bucket with the given index points to a valid record.
+// It returns the description of the problem or an empty string if the slot is valid.
+func (v *verifier) verifySlot(sl slot, bucketIdx uint32) string {
+	dl := v.db.datalog
+	if v.db.index.bucketIndex(sl.hash) != bucketIdx {
+		return "is in the wrong bucket"
+	}
+	seg := dl.segments[sl.segmentID]
+	if seg == nil || seg.compacted {
+		return fmt.Sprintf("points to missing segment %d", sl.segmentID)
+	}
+	off := int64(sl.offset)
+	if off < int64(headerSize) || off+int64(encodedRecordSize(sl.kvSize())) > seg.size {
+		return fmt.Sprintf("points outside of segment %s", seg.name)
+	}
+	rec, err := dl.readRecordAt(sl.segmentID, sl.offset)
+	if err != nil {
+		return fmt.Sprintf("points to an invalid record in segment %s", seg.name)
+	}
+	size := int64(recordSize(uint32(len(rec.key)+len(rec.value)), rec.rtype))
+	if off+size > seg.size {
+		return fmt.Sprintf("points outside of segment %s", seg.name)
+	}
+	data, err := seg.Slice(off, off+size)
+	if err != nil {
+		return fmt.Sprintf("points to an invalid record in segment %s", seg.name)
+	}
+	if binary.LittleEndian.Uint32(data[size-4:]) != crc32.ChecksumIEEE(data[:size-4]) {
+		return fmt.Sprintf("points to an invalid record in segment %s", seg.name)
+	}
+	if rec.rtype != recordTypePut && rec.rtype != recordTypePutTTL && rec.rtype != recordTypeMerge {
+		return fmt.Sprintf("points to a record of type %d in segment %s", rec.rtype, seg.name)
+	}
+	if len(rec.key) != int(sl.keySize) || len(rec.value) != int(sl.valueSize) {
+		return fmt.Sprintf("size doesn't match the record in segment %s", seg.name)
+	}
+	if v.db.hash(rec.key) != sl.hash {
+		return fmt.Sprintf("hash doesn't match the record key in segment %s", seg.name)
+	}
+	v.liveBytes[seg.id] += size
+	return ""
+}
+
+// verifySegmentMeta checks that the size of deleted records stored in the segment metadata matches the size of the
+// records not pointed to by the index.
+func (v *verifier) verifySegmentMeta() {
+	for _, seg := range v.db.datalog.segmentsBySequenceID() {
+		if seg.compacted || !v.scanned[seg.id] {
+			continue
+		}
+		deletedBytes := seg.size - int64(headerSize) - v.liveBytes[seg.id]
+		if int64(seg.meta.DeletedBytes) != deletedBytes {
+			v.problem(segmentMetaName(seg.id, seg.sequenceID), 0, "deleted bytes %d don't match %d bytes of deleted records",
+				seg.meta.DeletedBytes, deletedBytes)
+		}
+	}
+}
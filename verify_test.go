@@ -0,0 +1,175 @@
+package pogreb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/akrylysov/pogreb/internal/assert"
+)
+
+func TestVerify(t *testing.T) {
+	clock := newTestClock()
+	opts := &Options{
+		MergeOperator:              Int64AddOperator,
+		MaxSegmentSize:             1024,
+		CompactionMinSegmentSize:   520,
+		CompactionMinFragmentation: 0.02,
+		now:                        clock.now,
+	}
+	db, err := createTestDB(opts)
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	report, err := db.Verify(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, VerifyReport{Segments: 1, Buckets: 1}, report)
+
+	// Fill the DB with all types of records.
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i), byte(i)}))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete([]byte{byte(i)}))
+	}
+	b := NewWriteBatch()
+	assert.Nil(t, b.Put([]byte{1, 1}, []byte{1}))
+	assert.Nil(t, b.Delete([]byte{60}))
+	assert.Nil(t, db.Write(b))
+	assert.Nil(t, db.PutWithTTL([]byte{2, 1}, []byte{1}, time.Second))
+	assert.Nil(t, db.PutWithTTL([]byte{2, 2}, []byte{1}, time.Hour))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Merge([]byte{3, 1}, int64Bytes(1)))
+	}
+	clock.advance(time.Minute)
+
+	report, err = db.Verify(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, true, report.OK())
+	assert.Equal(t, int(db.Count()), report.Keys)
+	assert.Equal(t, countSegments(t, db), report.Segments)
+
+	cr, err := db.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, true, cr.CompactedSegments > 0)
+	report, err = db.Verify(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, true, report.OK())
+
+	// Reopen and recover.
+	assert.Nil(t, db.Close())
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	report, err = db.Verify(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, true, report.OK())
+	assert.Nil(t, db.Close())
+	assert.Nil(t, touchFile(testFS, testDBName+"/"+lockName))
+	db, err = Open(testDBName, opts)
+	assert.Nil(t, err)
+	report, err = db.Verify(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, true, report.OK())
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = db.Verify(cctx)
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Nil(t, db.Close())
+	_, err = db.Verify(ctx)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestVerifyCorruption(t *testing.T) {
+	opts := &Options{
+		MaxSegmentSize: 1024,
+	}
+	ctx := context.Background()
+
+	verify := func(t *testing.T, corrupt func(db *DB)) []string {
+		db, err := createTestDB(opts)
+		assert.Nil(t, err)
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put([]byte{byte(i)}, []byte{byte(i)}))
+		}
+		assert.Nil(t, db.Delete([]byte{0}))
+		corrupt(db)
+		report, err := db.Verify(ctx)
+		assert.Nil(t, err)
+		var problems []string
+		for _, p := range report.Problems {
+			problems = append(problems, p.Error())
+		}
+		assert.Nil(t, db.Close())
+		return problems
+	}
+
+	t.Run("record", func(t *testing.T) {
+		problems := verify(t, func(db *DB) {
+			seg := db.datalog.segments[0]
+			_, err := seg.WriteAt([]byte{0xff}, int64(headerSize)+7)
+			assert.Nil(t, err)
+		})
+		assert.Equal(t, []string{
+			"database is corrupted: checksum mismatch at offset 512 in 00000-1.psg",
+		}, problems)
+	})
+
+	t.Run("slot", func(t *testing.T) {
+		var slotProblem string
+		problems := verify(t, func(db *DB) {
+			// Corrupt the slot of key 1, the record following the deleted record of key 0.
+			h := db.hash([]byte{1})
+			it := db.index.newBucketIterator(db.index.bucketIndex(h))
+			for slotProblem == "" {
+				b, err := it.next()
+				assert.Nil(t, err)
+				for i := 0; i < slotsPerBucket; i++ {
+					if b.slots[i].hash != h || b.slots[i].offset != uint32(headerSize)+12 {
+						continue
+					}
+					b.slots[i].offset++
+					assert.Nil(t, b.write())
+					name := indexMainName
+					if b.file == db.index.overflow {
+						name = indexOverflowName
+					}
+					slotProblem = fmt.Sprintf("database is corrupted: slot %d points to an invalid record in segment 00000-1.psg at offset %d in %s", i, b.offset, name)
+					break
+				}
+			}
+		})
+		assert.Equal(t, []string{
+			slotProblem,
+			"database is corrupted: deleted bytes 12 don't match 24 bytes of deleted records at offset 0 in 00000-1.psg.pmt",
+		}, problems)
+	})
+
+	t.Run("meta", func(t *testing.T) {
+		problems := verify(t, func(db *DB) {
+			db.index.numKeys++
+			db.datalog.segments[1].meta.DeletedBytes++
+		})
+		assert.Equal(t, []string{
+			"database is corrupted: number of keys 100 doesn't match 99 keys in the index at offset 0 in index.pmt",
+			"database is corrupted: deleted bytes 1 don't match 0 bytes of deleted records at offset 0 in 00001-2.psg.pmt",
+		}, problems)
+	})
+
+	t.Run("orphaned overflow bucket", func(t *testing.T) {
+		var off int64
+		problems := verify(t, func(db *DB) {
+			b, err := db.index.createOverflowBucket()
+			assert.Nil(t, err)
+			off = b.offset
+		})
+		assert.Equal(t, []string{
+			fmt.Sprintf("database is corrupted: orphaned overflow bucket at offset %d in overflow.pix", off),
+		}, problems)
+	})
+}